@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"slices"
+
+	"github.com/muesli/termenv"
+)
+
+// Span is a part of a line styled by a rule.
+type Span struct {
+	// Rule is the number of the rule, or 0 for the style of a block started
+	// on an earlier line or a line that no keep-only rule matches.
+	Rule int
+	// Kind is what styled the span: "" for the style of a capture group,
+	// "path", "value" for a map, thresholds or a gradient, "replace",
+	// "block", "dim" or "hide".
+	Kind string
+	// Group is the capture group, and Name its name, if any.
+	Group int
+	Name  string
+	Start int
+	End   int
+	// Text is the text of the span, after the replacements of the line.
+	Text string
+	// Codes are the SGR parameters of the style.
+	Codes []string
+}
+
+// annotation records the spans that colorize styles in a line. Its methods
+// do nothing on a nil annotation, when the spans aren't needed.
+type annotation []Span
+
+// add records the span of a group of the rule. rule may be nil.
+func (a *annotation) add(
+	rule *Rule,
+	kind string,
+	group, start, end int,
+	codes []string,
+) {
+	if a == nil {
+		return
+	}
+
+	span := Span{
+		Kind:  kind,
+		Group: group,
+		Start: start,
+		End:   end,
+		Codes: slices.Clone(codes),
+	}
+	if rule != nil {
+		span.Rule = rule.Number
+		if rule.Regexp != nil {
+			if names := rule.Regexp.SubexpNames(); group < len(names) {
+				span.Name = names[group]
+			}
+		}
+	}
+	*a = append(*a, span)
+}
+
+// block records the style of a whole line: the style of its block, and the
+// faint style of the dim rule, if any, that ends block.
+func (a *annotation) block(line string, block []string, start, dim *Rule) {
+	if a == nil {
+		return
+	}
+
+	faint := dim != nil && block[len(block)-1] == termenv.FaintSeq
+	if faint {
+		block = block[:len(block)-1]
+	}
+
+	if len(block) > 0 {
+		if start != nil && len(blockStyle(start.Styles())) == 0 {
+			start = nil
+		}
+		a.add(start, "block", 0, 0, len(line), block)
+	}
+	if faint {
+		a.add(dim, "dim", 0, 0, len(line), []string{termenv.FaintSeq})
+	}
+}
+
+// text sets the text of the spans in the line, once it's replaced.
+func (a *annotation) text(line string) {
+	if a == nil {
+		return
+	}
+	for i := range *a {
+		(*a)[i].Text = line[(*a)[i].Start:(*a)[i].End]
+	}
+}
+
+// reset forgets the spans, when a rule overwrites the others.
+func (a *annotation) reset() {
+	if a != nil {
+		*a = (*a)[:0]
+	}
+}
+
+// move moves the spans along with the text of the edits. Spans whose text was
+// replaced are dropped.
+func (a *annotation) move(edits []edit) {
+	if a == nil {
+		return
+	}
+
+	*a = slices.DeleteFunc(*a, func(span Span) bool {
+		return movePos(span.Start, edits) >= movePos(span.End, edits)
+	})
+	for i := range *a {
+		(*a)[i].Start = movePos((*a)[i].Start, edits)
+		(*a)[i].End = movePos((*a)[i].End, edits)
+	}
+}
@@ -0,0 +1,231 @@
+package cmd_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"cshift/cmd"
+)
+
+// annotate annotates the lines in order with the rules of content, and
+// returns the spans of each line like "#1 value 1(name) "text" 1;31".
+func annotate(t *testing.T, content string, lines ...string) [][]string {
+	cmdRules, err := cmd.DecodeRules(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matcher := cmd.NewMatcher(cmdRules.AllRules())
+	var state cmd.LineState
+	spans := make([][]string, len(lines))
+	for i, line := range lines {
+		var lineSpans []cmd.Span
+		_, lineSpans, state = matcher.Annotate(line, state)
+
+		for _, span := range lineSpans {
+			fields := []string{fmt.Sprintf("#%d", span.Rule)}
+			if span.Kind != "" {
+				fields = append(fields, span.Kind)
+			}
+			group := fmt.Sprint(span.Group)
+			if span.Name != "" {
+				group += "(" + span.Name + ")"
+			}
+			fields = append(fields, group, fmt.Sprintf("%q", span.Text))
+			if len(span.Codes) > 0 {
+				fields = append(fields, strings.Join(span.Codes, ";"))
+			}
+			spans[i] = append(spans[i], strings.Join(fields, " "))
+		}
+	}
+	return spans
+}
+
+func TestAnnotate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    string
+		lines    []string
+		expected [][]string
+	}{
+		{
+			name: "table",
+			rules: `
+[table]
+header = '^Filesystem'
+
+[[rules]]
+column = 'Use%'
+regexp = '^9\d%$'
+colors = 'red'
+
+[[rules]]
+column = 'Avail'
+colors = 'bold,green'
+`,
+			lines: []string{
+				"99% before",
+				"Filesystem  Size Avail Use% Mounted on",
+				"/dev/sda1    99G   10G  91% /mnt/99%",
+			},
+			expected: [][]string{
+				nil,
+				nil,
+				{`#1 0 "91%" 31`, `#2 0 "10G" 1`},
+			},
+		},
+		{
+			name: "numbers",
+			rules: `
+[[rules]]
+regexp = 'coverage: (\S+)'
+colors = 'bold,'
+thresholds = [{ value = 80, colors = 'green' }]
+
+[[rules]]
+regexp = '\d+ms'
+gradient = [
+  { value = '10ms', color = '#00ff00' },
+  { value = '1s', color = '#ff0000' },
+]
+`,
+			lines: []string{
+				"coverage: 85.0% in 505ms",
+				"coverage: 10.0% in 5ms",
+			},
+			expected: [][]string{
+				{
+					`#1 0 "coverage: 85.0%" 1`,
+					`#1 value 1 "85.0%" 32`,
+					`#2 value 0 "505ms" 38;2;128;128;0`,
+				},
+				{
+					`#1 0 "coverage: 10.0%" 1`,
+					`#2 value 0 "5ms" 38;2;0;255;0`,
+				},
+			},
+		},
+		{
+			name: "map",
+			rules: `
+[[rules]]
+regexp = '^(\w+)'
+flags = 'i'
+colors = ',blue'
+map = [{ value = 'error', colors = 'red' }]
+`,
+			lines: []string{
+				"ERROR disk full",
+				"info: started",
+			},
+			expected: [][]string{
+				{`#1 1 "ERROR" 34`, `#1 value 1 "ERROR" 31`},
+				{`#1 1 "info" 34`},
+			},
+		},
+		{
+			name: "hash",
+			rules: `
+[[rules]]
+regexp = 'user=(\w+)'
+colors = ',bold hash'
+palette = ['red', 'green', 'blue']
+`,
+			lines: []string{
+				"user=alice",
+				"user=eve",
+				"user=alice",
+			},
+			expected: [][]string{
+				{`#1 1 "alice" 1;34`},
+				{`#1 1 "eve" 1;31`},
+				{`#1 1 "alice" 1;34`},
+			},
+		},
+		{
+			name: "pcre named groups",
+			rules: `
+[[rules]]
+regexp = '(?<time>[\d.]+) (\w+)'
+engine = 'pcre'
+colors = ',blue'
+styles = { time = 'green' }
+`,
+			lines:    []string{"time=12.5 ms"},
+			expected: [][]string{{`#1 1 "ms" 34`, `#1 2(time) "12.5" 32`}},
+		},
+		{
+			name: "replace",
+			rules: `
+[[rules]]
+regexp = '\b(E[A-Z]+)\b'
+colors = ',red'
+
+[[rules]]
+regexp = '= -1 (ENOENT)$'
+replace = '= -1 $1 (No such file or directory)'
+colors = 'hiblack'
+
+[[rules]]
+regexp = '^open'
+replace = 'openat'
+phase = 'after'
+colors = 'yellow'
+
+[[rules]]
+regexp = ', 0x[0-9a-f]+'
+replace = ''
+`,
+			lines: []string{"open(\"/x\", 0x80000) = -1 ENOENT"},
+			expected: [][]string{{
+				`#1 1 "ENOENT" 31`,
+				`#2 replace 0 "= -1 ENOENT (No such file or directory)" 90`,
+				`#3 replace 0 "openat" 33`,
+			}},
+		},
+		{
+			name: "filter",
+			rules: `
+[[rules]]
+regexp = '^debug'
+action = 'hide'
+
+[[rules]]
+regexp = '^warn'
+action = 'dim'
+colors = 'yellow'
+
+[[rules]]
+regexp = '^(info|warn|debug)'
+action = 'keep-only'
+`,
+			lines: []string{
+				"info: started",
+				"debug: details",
+				"warn: slow",
+				"error: failed",
+			},
+			expected: [][]string{
+				nil,
+				{`#1 hide 0 "debug: details"`},
+				{`#2 0 "warn" 33`, `#2 dim 0 "warn: slow" 2`},
+				{`#0 hide 0 "error: failed"`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spans := annotate(t, test.rules, test.lines...)
+			if !slices.EqualFunc(spans, test.expected, slices.Equal) {
+				t.Errorf(
+					"expected the spans %q, but got %q",
+					test.expected,
+					spans,
+				)
+			}
+		})
+	}
+}
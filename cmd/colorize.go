@@ -3,6 +3,7 @@ package cmd
 import (
 	"iter"
 	"log/slog"
+	"maps"
 	"slices"
 	"strings"
 
@@ -43,29 +44,145 @@ func (i Index) AddStyle(idx int, style ...string) {
 	i[idx] = append(i[idx], style...)
 }
 
+// ResetStyle ends the styles before idx. The reset goes before the styles
+// that start at idx, so that they aren't reset, whichever rule added them
+// first, and only once.
 func (i Index) ResetStyle(idx int) {
-	i.AddStyle(idx, termenv.ResetSeq)
+	if len(i[idx]) > 0 && i[idx][0] == termenv.ResetSeq {
+		return
+	}
+	i[idx] = append([]string{termenv.ResetSeq}, i[idx]...)
+}
+
+// Base styles the whole line with base, below the other styles: it's
+// applied at the start and again after every reset.
+func (i Index) Base(base []string) {
+	for pos := range i {
+		i.reapply(pos, base)
+	}
+
+	i[0] = append(slices.Clone(base), i[0]...)
+}
+
+// reapply applies codes again after the resets at pos.
+func (i Index) reapply(pos int, codes []string) {
+	styles := i[pos]
+	if !slices.Contains(styles, termenv.ResetSeq) {
+		return
+	}
+
+	var applied []string
+	for _, style := range styles {
+		applied = append(applied, style)
+		if style == termenv.ResetSeq {
+			applied = append(applied, codes...)
+		}
+	}
+	i[pos] = applied
+}
+
+// active returns the styles applied before pos.
+func (i Index) active(pos int) []string {
+	var styles []string
+	for _, p := range slices.Sorted(maps.Keys(i)) {
+		if p >= pos {
+			break
+		}
+		for _, style := range i[p] {
+			if style == termenv.ResetSeq {
+				styles = styles[:0]
+			} else {
+				styles = append(styles, style)
+			}
+		}
+	}
+	return styles
+}
+
+// Overlay styles the text from start to end with codes over the other
+// styles, which continue after it.
+func (i Index) Overlay(start, end int, codes []string) {
+	after := i.active(end)
+	for pos := range i {
+		if pos > start && pos < end {
+			i.reapply(pos, codes)
+		}
+	}
+	i.AddStyle(start, codes...)
+
+	if len(i[end]) > 0 && i[end][0] == termenv.ResetSeq {
+		return
+	}
+	i[end] = append(append([]string{termenv.ResetSeq}, after...), i[end]...)
 }
 
-func (i Index) Extent(line string, matches [][]int, colors []string) {
-loop:
+// Extent styles the groups of the matches with the styles of the rule. A
+// group without a style is skipped: resetting at its end would also end the
+// styles of the groups around it. The styled groups are added to notes.
+func (i Index) Extent(
+	line string,
+	matches [][]int,
+	rule *Rule,
+	notes *annotation,
+) {
+	styles := rule.Styles()
 	for match := range RegexMatches(matches) {
 		idx, start, end := match.Values()
 
-		cfgStyle := strings.TrimSpace(colors[idx%len(colors)])
-
-		for style := range strings.SplitSeq(cfgStyle, " ") {
-			style = strings.ToLower(strings.TrimSpace(style))
-			if style == "path" {
-				i.ExtentPath(line, start, end)
-				continue loop
+		style := styles[idx%len(styles)]
+		if len(style.Codes) == 0 && !style.Path && !style.Hash {
+			continue
+		}
+		codes := style.Codes
+		if style.Hash {
+			color := HashColor(line[start:end], style.Palette)
+			if color != "" {
+				codes = append(slices.Clone(codes), color)
 			}
+		}
+		i.AddStyle(start, codes...)
+
+		if style.Path {
+			i.ExtentPath(line, start, end)
+			notes.add(rule, "path", idx, start, end, codes)
+			continue
+		}
+		notes.add(rule, "", idx, start, end, codes)
+
+		i.ResetStyle(end)
+	}
+}
 
-			seq := GetColorCode(style)
-			i.AddStyle(start, seq)
+// Values styles the value in the first group of each match, or the whole
+// match without groups, with the map, the thresholds or the gradient of the
+// rule. The styled values are added to notes.
+func (i Index) Values(
+	line string,
+	matches [][]int,
+	rule *Rule,
+	notes *annotation,
+) {
+	if len(rule.Map) == 0 && len(rule.Thresholds) == 0 &&
+		len(rule.Gradient) == 0 {
+		return
+	}
+
+	for _, match := range matches {
+		group, start, end := 0, match[0], match[1]
+		if len(match) > 2 {
+			group, start, end = 1, match[2], match[3]
+		}
+		if start < 0 {
+			continue
 		}
 
+		codes := rule.valueStyle(line[start:end])
+		if len(codes) == 0 {
+			continue
+		}
+		i.AddStyle(start, codes...)
 		i.ResetStyle(end)
+		notes.add(rule, "value", group, start, end, codes)
 	}
 }
 
@@ -92,7 +209,7 @@ func (i Index) ExtentPath(line string, start, end int) {
 			termenv.ANSIGreen.Sequence(false),
 			termenv.BoldSeq,
 		)
-		i.AddStyle(end, termenv.ResetSeq)
+		i.ResetStyle(end)
 		return
 	}
 
@@ -102,7 +219,7 @@ func (i Index) ExtentPath(line string, start, end int) {
 			termenv.ANSIRed.Sequence(false),
 			termenv.BoldSeq,
 		)
-		i.AddStyle(end, termenv.ResetSeq)
+		i.ResetStyle(end)
 		return
 	}
 
@@ -139,50 +256,255 @@ func (i Index) ExtentPath(line string, start, end int) {
 	i.AddStyle(basePathIndex, termenv.ResetSeq)
 }
 
+// Colorize styles the parts of the line matched by the rules. The line may
+// contain any bytes; its bytes are returned unchanged between the inserted
+// escape sequences, even if they aren't valid UTF-8.
 func Colorize(line string, rules []Rule) string {
-	index := make(Index)
+	return ColorizeFrom(line, rules, 0)
+}
+
+// ColorizeFrom colorizes the line like Colorize, but only returns the part of
+// it that starts at offset from. The styles applied before from are repeated
+// at the start, so the part continues a colorized start of the line that was
+// already printed.
+func ColorizeFrom(line string, rules []Rule, from int) string {
+	colored, _ := colorize(line, rules, nil, from, LineState{}, nil)
+	return colored
+}
+
+// LineState is what rules carry over from one line to the next.
+type LineState struct {
+	// Block is the style of the lines in a block, started by a rule with
+	// count = "block".
+	Block []string
+	// Inside is the block rule whose block the line is in.
+	Inside *Rule
+	// State is the current named state, if any.
+	State *State
+	// Columns are the columns of the table, once its header was found.
+	Columns *Columns
+}
+
+// enterState updates the state with the states of the rules for the line,
+// and returns the state the line is in, if any.
+func enterState(line string, rules []Rule, state *LineState) *State {
 	for _, rule := range rules {
+		next := rule.State
+		if next != nil && next != state.State && next.Enter.MatchString(line) {
+			state.State, state.Inside = next, nil
+			return next
+		}
+	}
+
+	current := state.State
+	if current != nil && current.Leave != nil &&
+		current.Leave.MatchString(line) {
+		state.State, state.Inside = nil, nil
+	}
+	return current
+}
+
+// enterBlock updates the state with the block rules for the line, and returns
+// the block rule whose block the line is in, if any.
+func enterBlock(line string, rules []Rule, state *LineState) *Rule {
+	if rule := state.Inside; rule != nil {
+		if !rule.Indent {
+			if rule.End.MatchString(line) {
+				state.Inside = nil
+			}
+			return rule
+		}
+
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			return rule
+		}
+		state.Inside = nil
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Start != nil && rule.Start.MatchString(line) {
+			state.Inside = rule
+			return rule
+		}
+	}
+	return nil
+}
+
+// colorize colorizes the line like ColorizeFrom with the rules that are
+// candidates, and returns the state for the next line. All rules are
+// candidates if candidates is nil. The styled spans are added to notes, if
+// not nil.
+func colorize(
+	line string,
+	rules []Rule,
+	candidates []bool,
+	from int,
+	state LineState,
+	notes *annotation,
+) (string, LineState) {
+	header := enterTable(line, rules, &state)
+	var cells map[string]Cell
+
+	if current := enterState(line, rules, &state); current != nil {
+		rules, candidates = current.Rules, nil
+	}
+
+	block := state.Block
+	blockRule := enterBlock(line, rules, &state)
+	if blockRule != nil {
+		block = append(slices.Clone(block), blockStyle(blockRule.Styles())...)
+		if len(blockRule.Rules) > 0 {
+			rules, candidates = blockRule.Rules, nil
+		}
+	}
+	var dim *Rule
+
+	index := make(Index)
+
+	// The candidates were found in the line before its replacements.
+	replaced, before := replace(
+		line,
+		rules,
+		"before",
+		index,
+		&from,
+		nil,
+		notes,
+	)
+	if replaced != line {
+		line, candidates = replaced, nil
+	}
+
+	for i, rule := range rules {
 		re := rule.Regexp
-		if re == nil {
+		if re == nil || rule.Replace != nil ||
+			(candidates != nil && !candidates[i]) {
 			continue
 		}
 
-		colors := strings.Split(rule.Colors, ",")
-
-		matches := re.FindAllStringSubmatchIndex(line, -1)
+		var matches [][]int
+		if rule.Column == "" {
+			matches = re.FindAllStringSubmatchIndex(line, rule.Limit())
+		} else if !header && state.Columns != nil {
+			if cells == nil {
+				cells = state.Columns.Cells(line)
+			}
+			matches = findInCell(line, cells, &rule)
+		}
 
 		if len(matches) == 0 {
 			continue
 		}
 
+		switch rule.Count {
+		case "block":
+			state.Block = blockStyle(rule.Styles())
+			block = append(slices.Clone(block), state.Block...)
+		case "unblock":
+			block, state.Block = nil, nil
+		}
+		if rule.Action == "dim" {
+			block = append(slices.Clone(block), termenv.FaintSeq)
+			dim = &rules[i]
+		}
+
 		if rule.Overwrite {
 			slog.Debug("Overwriting other rules for current line")
 			index.Reset()
-			index.Extent(line, matches, colors)
+			notes.reset()
+			index.Extent(line, matches, &rule, notes)
+			index.Values(line, matches, &rule, notes)
 			break
 		}
 
-		index.Extent(line, matches, colors)
+		index.Extent(line, matches, &rule, notes)
+		index.Values(line, matches, &rule, notes)
+
+		if rule.Count == "stop" {
+			break
+		}
+	}
+
+	for _, r := range before {
+		index.Overlay(r.start, r.end, r.codes)
+		notes.add(r.rule, "replace", 0, r.start, r.end, r.codes)
+	}
+	line, _ = replace(line, rules, "after", index, &from, nil, notes)
+
+	if len(block) > 0 {
+		index.Base(block)
+		notes.block(line, block, blockRule, dim)
+	}
+	notes.text(line)
+
+	from = min(from, len(line))
+	if len(index) == 0 {
+		return line[from:] + "\x1b[" + termenv.ResetSeq + "m", state
 	}
 
 	var buf strings.Builder
-	size := len(line)
-	buf.Grow(2 * size)
+	buf.Grow(2 * len(line))
 
-	for i := range size {
-		if v, ok := index[i]; ok {
-			buf.WriteString("\x1b[" + join(v) + "m")
-		}
-		buf.WriteByte(line[i])
+	positions := slices.Sorted(maps.Keys(index))
+
+	if styles := index.active(from); len(styles) > 0 {
+		writeStyle(&buf, styles)
 	}
 
-	if v, ok := index[size]; ok {
-		buf.WriteString("\x1b[" + strings.Join(v, ";") + "m")
+	last := from
+	for _, pos := range positions {
+		if pos < from {
+			continue
+		}
+		buf.WriteString(line[last:pos])
+		writeStyle(&buf, index[pos])
+		last = pos
 	}
+	buf.WriteString(line[last:])
 
 	buf.WriteString("\x1b[" + termenv.ResetSeq + "m")
 
-	return buf.String()
+	return buf.String(), state
+}
+
+// findInCell returns the matches of a column rule in its cell of the line,
+// with offsets in the line.
+func findInCell(line string, cells map[string]Cell, rule *Rule) [][]int {
+	cell, ok := cells[rule.Column]
+	if !ok {
+		return nil
+	}
+
+	matches := rule.Regexp.FindAllStringSubmatchIndex(
+		line[cell.Start:cell.End],
+		rule.Limit(),
+	)
+	for _, match := range matches {
+		for i := range match {
+			if match[i] >= 0 {
+				match[i] += cell.Start
+			}
+		}
+	}
+	return matches
+}
+
+// blockStyle returns the style of a block: the first style of its rule.
+func blockStyle(styles []Style) []string {
+	for _, style := range styles {
+		if len(style.Codes) > 0 {
+			return style.Codes
+		}
+	}
+	return nil
+}
+
+// writeStyle writes the SGR sequence of the styles.
+func writeStyle(buf *strings.Builder, styles []string) {
+	buf.WriteString("\x1b[")
+	buf.WriteString(join(styles))
+	buf.WriteByte('m')
 }
 
 func join(s []string) string {
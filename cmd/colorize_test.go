@@ -0,0 +1,439 @@
+package cmd_test
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cshift/cmd"
+)
+
+var sgr = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func TestColorize(t *testing.T) {
+	cmdRules, err := cmd.LoadRulesFile("../rules/ping.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cmdRules.AllRules()
+
+	lines := []string{
+		"64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=10.2 ms",
+		"--- 1.1.1.1 ping statistics ---",
+		"",
+		"caf\xe9 64 bytes \xff\xfe from \xc3",
+	}
+
+	for _, line := range lines {
+		colored := cmd.Colorize(line, rules)
+		if stripped := sgr.ReplaceAllString(colored, ""); stripped != line {
+			t.Errorf("expected %q, but got %q", line, stripped)
+		}
+	}
+}
+
+func TestColorizeFrom(t *testing.T) {
+	cmdRules, err := cmd.LoadRulesFile("../rules/ping.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cmdRules.AllRules()
+
+	line := "64 bytes from 1.1.1.1: time=10.2 ms"
+	colored := cmd.ColorizeFrom(line, rules, 2)
+
+	if stripped := sgr.ReplaceAllString(colored, ""); stripped != line[2:] {
+		t.Fatalf("expected %q, but got %q", line[2:], stripped)
+	}
+	if colored[:len("\x1b[1;31m")] != "\x1b[1;31m" {
+		t.Fatalf(
+			"expected the style of %q to be repeated: %q",
+			line[:2],
+			colored,
+		)
+	}
+}
+
+// rendered returns the style of each byte of the colored line as a terminal
+// shows it: a color replaces the previous one, and an empty sequence resets
+// like 0.
+func rendered(colored string) []string {
+	var styles []string
+	style := map[string]string{}
+	last := 0
+	seqs := sgr.FindAllStringIndex(colored, -1)
+	for _, seq := range append(seqs, []int{len(colored), len(colored)}) {
+		var codes []string
+		for _, key := range slices.Sorted(maps.Keys(style)) {
+			codes = append(codes, style[key])
+		}
+		for range seq[0] - last {
+			styles = append(styles, strings.Join(codes, ";"))
+		}
+		last = seq[1]
+
+		for param := range strings.SplitSeq(colored[seq[0]:seq[1]], ";") {
+			param = strings.Trim(param, "\x1b[m")
+			code, _ := strconv.Atoi(param)
+			switch {
+			case seq[0] == seq[1]:
+			case code == 0:
+				clear(style)
+			case code >= 30 && code <= 39 || code >= 90 && code <= 97:
+				style["fg"] = param
+			case code >= 40 && code <= 49 || code >= 100 && code <= 107:
+				style["bg"] = param
+			default:
+				style[param] = param
+			}
+		}
+	}
+	return styles
+}
+
+// TestColorizeEscapes pins the sequences of single rules. Since the column
+// and value rules, a reset goes before the styles that start where it is, and
+// is added only once, and groups without a style add no sequences. The old
+// sequences show the same colors, but for groups without a style, which used
+// to end the style of the match around them.
+func TestColorizeEscapes(t *testing.T) {
+	tests := []struct {
+		regexp   string
+		colors   string
+		line     string
+		old      string
+		expected string
+		same     bool
+	}{
+		{
+			`foo`, "red", "a foo b foo",
+			"a \x1b[31mfoo\x1b[0m b \x1b[31mfoo\x1b[0m\x1b[0m",
+			"a \x1b[31mfoo\x1b[0m b \x1b[31mfoo\x1b[0m\x1b[0m",
+			true,
+		},
+		{
+			`a(b)c`, "yellow,red", "xabcx",
+			"x\x1b[33ma\x1b[31mb\x1b[0mc\x1b[0mx\x1b[0m",
+			"x\x1b[33ma\x1b[31mb\x1b[0mc\x1b[0mx\x1b[0m",
+			true,
+		},
+		// Groups ending together are reset once.
+		{
+			`(\d+) (ms)`, ",red,bold blue", "t 10 ms ok",
+			"t \x1b[31m10\x1b[0m \x1b[1;34mms\x1b[0;0m ok\x1b[0m",
+			"t \x1b[31m10\x1b[0m \x1b[1;34mms\x1b[0m ok\x1b[0m",
+			true,
+		},
+		{
+			`(a)b(c)`, "yellow", "xabcx",
+			"x\x1b[33;33ma\x1b[0mb\x1b[33mc\x1b[0;0mx\x1b[0m",
+			"x\x1b[33;33ma\x1b[0mb\x1b[33mc\x1b[0mx\x1b[0m",
+			true,
+		},
+		// Groups without a style add no sequences.
+		{
+			`x(\d+)`, ",green", "ax12 b",
+			"a\x1b[mx\x1b[32m12\x1b[0;0m b\x1b[0m",
+			"ax\x1b[32m12\x1b[0m b\x1b[0m",
+			true,
+		},
+		// A style starting where another ends isn't reset.
+		{
+			`(a)(b)`, "red,,blue", "xaby",
+			"x\x1b[31ma\x1b[0;34mb\x1b[0;0my\x1b[0m",
+			"x\x1b[31ma\x1b[34mb\x1b[0my\x1b[0m",
+			true,
+		},
+		// Groups without a style no longer end the style of the match.
+		{
+			`a(b)c`, "yellow,", "xabcx",
+			"x\x1b[33ma\x1b[mb\x1b[0mc\x1b[0mx\x1b[0m",
+			"x\x1b[33mabc\x1b[0mx\x1b[0m",
+			false,
+		},
+		{
+			`a(b)(c)`, "yellow,,red", "xabcx",
+			"x\x1b[33ma\x1b[mb\x1b[0;31mc\x1b[0;0mx\x1b[0m",
+			"x\x1b[33mab\x1b[31mc\x1b[0mx\x1b[0m",
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		cmdRules, err := cmd.DecodeRules(fmt.Sprintf(
+			"[[rules]]\nregexp = '%s'\ncolors = '%s'\n",
+			test.regexp,
+			test.colors,
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		colored := cmd.Colorize(test.line, cmdRules.AllRules())
+		if colored != test.expected {
+			t.Errorf(
+				"expected %s on %q to give %q, but got %q",
+				test.regexp,
+				test.line,
+				test.expected,
+				colored,
+			)
+		}
+
+		same := slices.Equal(rendered(test.old), rendered(colored))
+		if same != test.same {
+			t.Errorf(
+				"expected %s on %q to show the same colors as before: %v, "+
+					"but got %q for %q",
+				test.regexp,
+				test.line,
+				test.same,
+				rendered(colored),
+				rendered(test.old),
+			)
+		}
+	}
+}
+
+func TestColorizeCount(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = 'a'
+colors = 'red'
+count = 'once'
+
+[[rules]]
+regexp = 'b'
+colors = 'blue'
+count = 'stop'
+
+[[rules]]
+regexp = 'c'
+colors = 'green'
+
+[[rules]]
+regexp = '^BEGIN'
+colors = 'yellow'
+count = 'block'
+
+[[rules]]
+regexp = '^END'
+colors = 'yellow'
+count = 'unblock'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cmdRules.Rules
+
+	tests := map[string]string{
+		"aa": "\x1b[31ma\x1b[0ma\x1b[0m",
+		"bc": "\x1b[34mb\x1b[0mc\x1b[0m",
+		"ac": "\x1b[31ma\x1b[0;32mc\x1b[0m\x1b[0m",
+	}
+	for line, expected := range tests {
+		if colored := cmd.Colorize(line, rules); colored != expected {
+			t.Errorf("expected %q for %q, but got %q", expected, line, colored)
+		}
+	}
+
+	// Lines from BEGIN to END are yellow below their other styles.
+	input := "x\nBEGIN c\nx c x\nEND\nx\n"
+	expected := "> x\x1b[0m\n" +
+		"> \x1b[33;33mBEGIN\x1b[0;33m \x1b[32mc\x1b[0;33m\x1b[0m\n" +
+		"> \x1b[33mx \x1b[32mc\x1b[0;33m x\x1b[0m\n" +
+		"> \x1b[33mEND\x1b[0m\x1b[0m\n" +
+		"> x\x1b[0m\n"
+	colored := string(colorizeFile(t, rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+}
+
+func TestColorizeBlock(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = 'x'
+colors = 'green'
+
+[[rules]]
+start = '^Traceback'
+indent = true
+colors = 'red'
+
+[[rules.rules]]
+regexp = 'File'
+colors = 'blue'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "x\nTraceback x\n  File x\nError x\n"
+	expected := "> \x1b[32mx\x1b[0m\x1b[0m\n" +
+		"> \x1b[31mTraceback x\x1b[0m\n" +
+		"> \x1b[31m  \x1b[34mFile\x1b[0;31m x\x1b[0m\n" +
+		"> Error \x1b[32mx\x1b[0m\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+
+	for _, content := range []string{
+		"[[rules]]\nstart = 'a'",
+		"[[rules]]\nstart = 'a'\nend = 'b'\nindent = true",
+		"[[rules]]\nregexp = 'a'\nindent = true",
+		"[[rules]]\nstart = 'a'\nindent = true\n" +
+			"[[rules.rules]]\nstart = 'b'\nindent = true",
+	} {
+		if _, err := cmd.DecodeRules(content); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestColorizeStates(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = 'Name'
+colors = 'green'
+
+[states.server]
+enter = '^Server:'
+leave = '^$'
+
+[[states.server.rules]]
+regexp = 'Name'
+colors = 'red'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "Name\nServer:\n Name\n\nName\n"
+	expected := "> \x1b[32mName\x1b[0m\x1b[0m\n" +
+		"> Server:\x1b[0m\n" +
+		">  \x1b[31mName\x1b[0m\x1b[0m\n" +
+		"> \x1b[0m\n" +
+		"> \x1b[32mName\x1b[0m\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+
+	if _, err := cmd.DecodeRules("[states.a]\nleave = 'b'"); err == nil {
+		t.Error("expected an error for a state without enter")
+	}
+}
+
+func TestColorizeStatesOrder(t *testing.T) {
+	var content strings.Builder
+	var expected []string
+	for i := range 20 {
+		fmt.Fprintf(&content, "[[rules]]\nregexp = 'w%d'\n", i)
+		expected = append(expected, fmt.Sprintf("w%d", i))
+	}
+	content.WriteString(`
+[[rules]]
+regexp = 'first'
+priority = -1
+
+[states.zeta]
+enter = '(?<=^)server:'
+engine = 'pcre'
+flags = 'i'
+
+[[states.zeta.rules]]
+regexp = 'Name'
+colors = 'red'
+
+[states.alpha]
+enter = '^Server:'
+
+[[states.alpha.rules]]
+regexp = 'Name'
+colors = 'blue'
+`)
+
+	cmdRules, err := cmd.DecodeRules(content.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = append([]string{"first"}, expected...)
+	if exprs := regexps(cmdRules.Rules); !slices.Equal(exprs, expected) {
+		t.Errorf("expected the rules in order %q, but got %q", expected, exprs)
+	}
+
+	// Both states match, and the first one in the file is entered.
+	input := "Server:\nName\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if !strings.Contains(colored, "\x1b[31mName") {
+		t.Fatalf("expected Name in the zeta state, but got %q", colored)
+	}
+}
+
+func TestColorizeHash(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '(\w+)$'
+colors = ',bold hash'
+palette = ['#ff0000', '#00ff00', '#0000ff']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "start web\nstop api\nrestart web\n"
+	expected := "> start \x1b[1;38;2;0;255;0mweb\x1b[0m\x1b[0m\n" +
+		"> stop \x1b[1;38;2;0;0;255mapi\x1b[0m\x1b[0m\n" +
+		"> restart \x1b[1;38;2;0;255;0mweb\x1b[0m\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+
+	_, err = cmd.DecodeRules(`
+[[rules]]
+regexp = '\w+'
+colors = 'hash'
+palette = ['orange']
+`)
+	if err == nil {
+		t.Error("expected an error for an invalid palette color")
+	}
+}
+
+func TestColorizeNamedStyles(t *testing.T) {
+	for _, engine := range []string{"re2", "pcre"} {
+		cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = 'time=(?<time>[\d.]+) (?<unit>\w+) (\w+)'
+engine = '` + engine + `'
+styles = { time = 'bold green', unit = 'bold' }
+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input := "time=12.5 ms ok\n"
+		expected := "> time=\x1b[1;32m12.5\x1b[0m \x1b[1mms\x1b[0m ok\x1b[0m\n"
+		colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+		if colored != expected {
+			t.Errorf("%s: expected %q, but got %q", engine, expected, colored)
+		}
+	}
+
+	_, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '(?P<time>\d+)'
+styles = { tme = 'bold' }
+`)
+	if err == nil {
+		t.Error("expected an error for a style of a missing group")
+	}
+}
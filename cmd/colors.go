@@ -1,6 +1,60 @@
 package cmd
 
-import "github.com/muesli/termenv"
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/muesli/termenv"
+)
+
+// Style is the parsed style of a capture group.
+type Style struct {
+	// Codes are the SGR parameters of the style.
+	Codes []string
+	// Path styles the group as a path, after Codes.
+	Path bool
+	// Hash colors the group with a color of Palette picked by the hash of
+	// its text, so that equal values get the same color.
+	Hash    bool
+	Palette []string
+}
+
+// ColorProfile is the color profile of the output. Hex colors are converted
+// to it.
+var ColorProfile = termenv.TrueColor
+
+// DefaultPalette are the colors of hash styles in rules without a palette.
+var DefaultPalette = []string{
+	"red", "green", "yellow", "blue", "magenta", "cyan",
+	"hired", "higreen", "hiyellow", "hiblue", "himagenta", "hicyan",
+}
+
+// ParseStyles parses the comma separated styles of the capture groups of a
+// rule, e.g. ',bold green,path'.
+func ParseStyles(colors string) []Style {
+	var styles []Style
+	for cfgStyle := range strings.SplitSeq(colors, ",") {
+		var style Style
+		for name := range strings.SplitSeq(strings.TrimSpace(cfgStyle), " ") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "path" {
+				style.Path = true
+				break
+			}
+			if name == "hash" {
+				style.Hash = true
+				continue
+			}
+
+			if seq := GetColorCode(name); seq != "" {
+				style.Codes = append(style.Codes, seq)
+			}
+		}
+		styles = append(styles, style)
+	}
+	return styles
+}
 
 func GetColorCode(colorName string) string {
 	switch colorName {
@@ -82,3 +136,36 @@ func GetColorCode(colorName string) string {
 		return ""
 	}
 }
+
+// ParseHexColor parses a hex color like '#ff8800'.
+func ParseHexColor(color string) ([3]uint8, bool) {
+	var rgb [3]uint8
+	_, err := fmt.Sscanf(color, "#%02x%02x%02x", &rgb[0], &rgb[1], &rgb[2])
+	return rgb, err == nil && len(color) == 7
+}
+
+// PaletteColor returns the code of a color of a palette: a color name, or a
+// hex color like '#ff8800' converted to the color profile.
+func PaletteColor(color string) string {
+	if !strings.HasPrefix(color, "#") {
+		return GetColorCode(color)
+	}
+
+	c := ColorProfile.Color(color)
+	if c == nil {
+		return ""
+	}
+	return c.Sequence(false)
+}
+
+// HashColor returns the code of the color of the palette for text. It only
+// depends on text, so a value has the same color on every run.
+func HashColor(text string, palette []string) string {
+	if len(palette) == 0 {
+		palette = DefaultPalette
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(text))
+	return PaletteColor(palette[hash.Sum32()%uint32(len(palette))])
+}
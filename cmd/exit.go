@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// exitSignalTimeout is how long cshift waits for a signal it raised on itself
+// to terminate it.
+const exitSignalTimeout = 100 * time.Millisecond
+
+// ExitCode returns the exit status a shell would report for the error returned
+// by running a command: the status of the command, 128+n when it was killed by
+// signal n, 127 when it wasn't found and 126 when it couldn't be executed.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return 127
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.ENOEXEC):
+		return 126
+	default:
+		return 1
+	}
+}
+
+// Exit exits with the status of the command that ended with err. When the
+// command was killed by a signal, the same signal is raised on cshift so that
+// its parent sees the same termination. The terminal is restored first.
+func Exit(err error) {
+	restoreTerminal()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			exitSignal(status.Signal())
+		}
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "ChromaShift Error:", err)
+	}
+
+	os.Exit(ExitCode(err))
+}
+
+// exitSignal terminates cshift with the signal. Go ignores some signals, like
+// SIGUSR1, even with their default action, and cshift then exits with the
+// status a shell reports for the signal.
+func exitSignal(sig syscall.Signal) {
+	signal.Reset(sig)
+	if err := syscall.Kill(os.Getpid(), sig); err == nil {
+		// The signal is delivered asynchronously.
+		time.Sleep(exitSignalTimeout)
+	}
+	os.Exit(128 + int(sig))
+}
@@ -0,0 +1,51 @@
+package cmd_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestExitSignal(t *testing.T) {
+	if sig := os.Getenv("CSHIFT_TEST_EXIT"); sig != "" {
+		cmd.Exit(exec.Command("sh", "-c", "kill -"+sig+" $$").Run())
+	}
+
+	tests := []struct {
+		signal   string
+		signaled bool
+		code     int
+	}{
+		{"TERM", true, -1},
+		// Go ignores SIGUSR1, so cshift exits with its status instead.
+		{"USR1", false, 128 + int(syscall.SIGUSR1)},
+	}
+
+	for _, test := range tests {
+		run := exec.Command(os.Args[0], "-test.run=^TestExitSignal$")
+		run.Env = append(os.Environ(), "CSHIFT_TEST_EXIT="+test.signal)
+
+		var exitErr *exec.ExitError
+		if err := run.Run(); !errors.As(err, &exitErr) {
+			t.Fatalf(
+				"expected SIG%s to end the test, but got %v",
+				test.signal,
+				err,
+			)
+		}
+
+		status := exitErr.Sys().(syscall.WaitStatus)
+		if status.Signaled() != test.signaled ||
+			status.ExitStatus() != test.code {
+			t.Errorf(
+				"expected SIG%s to end the test, but got %v",
+				test.signal,
+				exitErr,
+			)
+		}
+	}
+}
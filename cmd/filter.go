@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// SelectRules loads the rules chosen with --rules or --as. Without those flags
+// it loads the rules configured for the command in args. The lines chosen with
+// --grep and --hide are filtered with any rules, even without a rules file.
+func SelectRules(config Config, args []string) (*CommandRules, error) {
+	cmdRules, err := selectRules(config, args)
+	if err != nil {
+		if len(Grep) == 0 && len(Hide) == 0 {
+			return nil, err
+		}
+		slog.Debug("Filtering lines without rules", "error", err)
+		cmdRules = &CommandRules{}
+	}
+
+	if err := cmdRules.AddFilters(Grep, Hide); err != nil {
+		return nil, err
+	}
+	return cmdRules, nil
+}
+
+func selectRules(config Config, args []string) (*CommandRules, error) {
+	if RulesFile != "" {
+		return LoadRulesFile(RulesFile)
+	}
+
+	if RulesAs != "" {
+		args = []string{RulesAs}
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No command to select rules for")
+	}
+
+	ruleFileName, err := GetRuleFileName(config, args)
+	if err == nil {
+		slog.Debug("Rules file name", "name", ruleFileName)
+		return LoadRules(ruleFileName)
+	}
+
+	if RulesAs == "" {
+		return nil, err
+	}
+
+	slog.Debug("No config exists for command", "command", RulesAs)
+
+	ruleFileName = RulesAs
+	if !strings.HasSuffix(ruleFileName, ".toml") {
+		ruleFileName += ".toml"
+	}
+	return LoadRules(ruleFileName)
+}
+
+// startFilter colorizes stdin to stdout using the rules selected with --rules
+// or --as. Without colors, the lines are only filtered.
+func startFilter() error {
+	config, err := LoadConfig()
+	if err != nil {
+		slog.Debug("Failed to load config", "error", err)
+	}
+
+	cmdRules, err := SelectRules(config, nil)
+	if err != nil && UseColor {
+		return err
+	}
+
+	if !UseColor {
+		stdout, _ := cmdRules.lineFilters()
+		copyFiltered(os.Stdout, os.Stdin, stdout)
+		return nil
+	}
+
+	rules := cmdRules.AllRules()
+	slog.Debug("Rules found", "count", len(rules))
+
+	output := Output{Rules: rules, Out: os.Stdout}
+	output.Copy(os.Stdin)
+	return nil
+}
+
+// lineFilters returns the rules that filter the lines of stdout and of stderr
+// when they aren't colorized.
+func (c *CommandRules) lineFilters() (stdout []Rule, stderr []Rule) {
+	switch {
+	case c == nil:
+	case c.PTY:
+		stdout, stderr = c.AllRules(), c.AllRules()
+	case c.HasStreams():
+		stdout, stderr = c.StreamRules(false), c.StreamRules(true)
+	case c.Stderr:
+		stderr = c.Rules
+	default:
+		stdout = c.Rules
+	}
+	return stdout, stderr
+}
+
+// copyFiltered copies the lines of r to w unchanged, except the lines that the
+// hide and keep-only rules drop. A line is printed once it's complete.
+func copyFiltered(w io.Writer, r io.Reader, rules []Rule) {
+	matcher := NewMatcher(rules)
+	if !matcher.Filters() {
+		if _, err := io.Copy(w, r); err != nil {
+			slog.Debug("Error copying output", "error", err)
+		}
+		return
+	}
+
+	reader := bufio.NewReaderSize(r, 32*1024)
+	writer := bufio.NewWriterSize(w, 32*1024)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" &&
+			matcher.Keep(strings.TrimRightFunc(line, unicode.IsSpace)) {
+			writer.WriteString(line)
+		}
+
+		// Everything read so far is printed.
+		if reader.Buffered() == 0 || err != nil {
+			if err := writer.Flush(); err != nil {
+				slog.Debug("Error writing output", "error", err)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				slog.Debug("Error reading output", "error", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package cmd_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestFilterWithoutColor(t *testing.T) {
+	if args := os.Getenv("CSHIFT_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"cshift"}, strings.Split(args, "\x1f")...)
+		cmd.Execute()
+		os.Exit(0)
+	}
+
+	rules := filepath.Join(t.TempDir(), "empty.toml")
+	if err := os.WriteFile(rules, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+	}{
+		{
+			"command",
+			[]string{"--hide", "b", "--", "printf", "a\\nb\\nc\\n"},
+			"",
+		},
+		{"grep", []string{"--grep", "a|c", "--", "printf", "a\\nb\\nc\\n"}, ""},
+		{"filter", []string{"--rules", rules, "--hide", "b"}, "a\nb\nc\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := exec.Command(
+				os.Args[0],
+				"-test.run=^TestFilterWithoutColor$",
+			)
+			run.Env = append(
+				os.Environ(),
+				"CSHIFT_TEST_ARGS="+strings.Join(test.args, "\x1f"),
+			)
+			run.Stdin = strings.NewReader(test.stdin)
+
+			// The output is a pipe, so it isn't colorized.
+			output, err := run.CombinedOutput()
+			if err != nil {
+				t.Fatalf("cshift failed: %v\n%s", err, output)
+			}
+			if got := string(output); got != "a\nc\n" {
+				t.Errorf("expected the filtered lines, but got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var FollowLines int
+
+// labelColors are cycled through to color the label of each followed file.
+var labelColors = []string{
+	"cyan",
+	"magenta",
+	"yellow",
+	"green",
+	"blue",
+	"red",
+}
+
+func init() {
+	followCmd.Flags().
+		StringVar(&RulesFile, "rules", "", "specify path to the rules file to use")
+	followCmd.Flags().
+		StringVar(&RulesAs, "as", "", "use the rules of the given command")
+	followCmd.Flags().
+		StringArrayVar(&Grep, "grep", nil, "only print lines matching this regexp")
+	followCmd.Flags().
+		StringArrayVar(&Hide, "hide", nil, "hide lines matching this regexp")
+	followCmd.Flags().
+		IntVarP(&FollowLines, "lines", "n", 10, "number of existing lines to print first")
+	rootCmd.AddCommand(followCmd)
+}
+
+var followCmd = &cobra.Command{
+	Use:   "follow --as <command> file...",
+	Short: "Follow and colorize log files",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if RulesFile == "" && RulesAs == "" {
+			return errors.New("either --as or --rules is required")
+		}
+
+		config, err := LoadConfig()
+		if err != nil {
+			slog.Debug("Failed to load config", "error", err)
+		}
+
+		cmdRules, err := SelectRules(config, nil)
+		if err != nil {
+			return fmt.Errorf("failed to load rules: %w", err)
+		}
+
+		rules := cmdRules.AllRules()
+		files := make([]*FollowedFile, len(args))
+		width := 0
+		for _, path := range args {
+			width = max(width, len(path))
+		}
+
+		for i, path := range args {
+			output := &Output{Rules: rules, Out: os.Stdout, Plain: !UseColor}
+			switch {
+			case len(args) == 1:
+			case UseColor:
+				color := GetColorCode(labelColors[i%len(labelColors)])
+				output.Prefix = fmt.Sprintf(
+					"\x1b[%sm%-*s\x1b[0m ",
+					color,
+					width,
+					path,
+				)
+			default:
+				output.Prefix = fmt.Sprintf("%-*s ", width, path)
+			}
+			files[i] = &FollowedFile{Path: path, Output: output}
+		}
+
+		dirs := make([]string, 0, len(files))
+		for _, file := range files {
+			dirs = append(dirs, filepath.Dir(file.Path))
+			file.Open(FollowLines)
+		}
+
+		w, err := newWatcher(dirs)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		for {
+			for _, file := range files {
+				file.Update()
+			}
+			if err := w.Wait(); err != nil {
+				return err
+			}
+		}
+	},
+}
+
+// FollowedFile is a file that is read as it grows. It is reopened when the
+// file is replaced (rotated) and read from the start when it is truncated.
+type FollowedFile struct {
+	Path   string
+	Output *Output
+
+	file   *os.File
+	info   os.FileInfo
+	offset int64
+	// tail is the end of what was read, to tell a file that was truncated
+	// and grew back past the offset from one that was appended to.
+	tail []byte
+}
+
+// tailSize is the length of the end of the read content that is compared.
+const tailSize = 64
+
+// Open opens the file and prints its last n lines.
+func (f *FollowedFile) Open(n int) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		slog.Debug("Failed to open file", "path", f.Path, "error", err)
+		return
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		slog.Debug("Failed to stat file", "path", f.Path, "error", err)
+		file.Close()
+		return
+	}
+
+	f.file = file
+	f.info = info
+	f.offset = lastLinesOffset(file, info.Size(), n)
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		slog.Debug("Failed to seek file", "path", f.Path, "error", err)
+	}
+
+	f.tail = make([]byte, min(f.offset, tailSize))
+	if _, err := file.ReadAt(f.tail, f.offset-int64(len(f.tail))); err != nil {
+		slog.Debug("Failed to read file", "path", f.Path, "error", err)
+	}
+}
+
+// Update prints everything appended to the file since the last update.
+func (f *FollowedFile) Update() {
+	if f.file == nil {
+		f.Open(-1)
+		if f.file == nil {
+			return
+		}
+	}
+
+	info, err := os.Stat(f.Path)
+	switch {
+	case err != nil:
+		// The file was moved away; print what is left of it until a new file
+		// appears in its place.
+		f.read()
+		return
+	case !os.SameFile(info, f.info):
+		slog.Debug("File was replaced", "path", f.Path)
+		f.read()
+		f.file.Close()
+		f.file = nil
+		f.Open(-1)
+	case f.truncated(info):
+		slog.Debug("File was truncated", "path", f.Path)
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			slog.Debug("Failed to seek file", "path", f.Path, "error", err)
+		}
+		f.offset = 0
+		f.tail = f.tail[:0]
+		f.info = info
+	default:
+		f.info = info
+	}
+
+	if f.file != nil {
+		f.read()
+	}
+}
+
+// truncated reports whether the file was truncated since the last update:
+// it's shorter than what was read, or it changed and what was read last
+// isn't at its end anymore, like after a copytruncate rotation that grew
+// past the offset before it was noticed.
+func (f *FollowedFile) truncated(info os.FileInfo) bool {
+	if info.Size() < f.offset {
+		return true
+	}
+	if info.Size() == f.info.Size() && info.ModTime().Equal(f.info.ModTime()) {
+		return false
+	}
+
+	tail := make([]byte, len(f.tail))
+	_, err := f.file.ReadAt(tail, f.offset-int64(len(tail)))
+	return err != nil || !bytes.Equal(tail, f.tail)
+}
+
+func (f *FollowedFile) read() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.file.Read(buf)
+		if n > 0 {
+			f.offset += int64(n)
+			f.tail = append(f.tail, buf[:n]...)
+			f.tail = f.tail[max(0, len(f.tail)-tailSize):]
+			_, _ = f.Output.Write(buf[:n])
+		}
+		if err != nil {
+			if err != io.EOF {
+				slog.Debug("Failed to read file", "path", f.Path, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// lastLinesOffset returns the offset of the last n lines of the file. A
+// negative n returns 0 so that the whole file is read.
+func lastLinesOffset(file *os.File, size int64, n int) int64 {
+	if n < 0 {
+		return 0
+	}
+	if n == 0 {
+		return size
+	}
+
+	const chunkSize = 8 * 1024
+	buf := make([]byte, chunkSize)
+
+	count := 0
+	for offset := size; offset > 0; {
+		start := max(0, offset-chunkSize)
+		chunk := buf[:offset-start]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return size
+		}
+
+		for i := len(chunk) - 1; i >= 0; i-- {
+			pos := start + int64(i)
+			// the trailing newline doesn't start a new line
+			if chunk[i] != '\n' || pos == size-1 {
+				continue
+			}
+
+			count++
+			if count == n {
+				return pos + 1
+			}
+		}
+
+		offset = start
+	}
+
+	return 0
+}
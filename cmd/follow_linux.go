@@ -0,0 +1,64 @@
+//go:build linux
+
+package cmd
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollInterval is how long Wait blocks without an event before returning, so
+// that changes missed by inotify (e.g. on network file systems) are noticed.
+const pollInterval = time.Second
+
+// watcher waits for changes in the directories of the followed files using
+// inotify. Watching the directories instead of the files also reports files
+// that are created or moved into place by log rotation.
+type watcher struct {
+	fd  int
+	buf []byte
+}
+
+func newWatcher(dirs []string) (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	const mask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CREATE |
+		unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+		unix.IN_CLOSE_WRITE
+
+	for _, dir := range dirs {
+		if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+			slog.Debug("Failed to watch directory", "path", dir, "error", err)
+		}
+	}
+
+	return &watcher{fd: fd, buf: make([]byte, 64*unix.SizeofInotifyEvent)}, nil
+}
+
+// Wait blocks until something changes in the watched directories or the poll
+// interval passes.
+func (w *watcher) Wait() error {
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	_, err := unix.Poll(fds, int(pollInterval.Milliseconds()))
+	if err != nil && err != unix.EINTR {
+		return err
+	}
+
+	// Drain the pending events; the files are checked regardless of which
+	// one changed.
+	for {
+		_, err := unix.Read(w.fd, w.buf)
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+func (w *watcher) Close() error {
+	return unix.Close(w.fd)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package cmd
+
+import "time"
+
+// pollInterval is how often the followed files are checked for changes.
+const pollInterval = 250 * time.Millisecond
+
+// watcher polls the followed files on platforms without inotify.
+type watcher struct{}
+
+func newWatcher(dirs []string) (*watcher, error) {
+	return &watcher{}, nil
+}
+
+// Wait blocks for the poll interval.
+func (w *watcher) Wait() error {
+	time.Sleep(pollInterval)
+	return nil
+}
+
+func (w *watcher) Close() error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cshift/cmd"
+)
+
+// followOutput returns an Output without rules that prints to a temporary
+// file, and a function that returns what it printed since the last call.
+func followOutput(t *testing.T) (*cmd.Output, func() string) {
+	f, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	output := &cmd.Output{Out: f}
+	read := 0
+	return output, func() string {
+		output.Flush()
+		content, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		printed := strings.ReplaceAll(string(content[read:]), "\x1b[0m", "")
+		read = len(content)
+		return printed
+	}
+}
+
+func TestFollowedFileLines(t *testing.T) {
+	long := strings.Repeat("x", 20000)
+
+	tests := []struct {
+		content  string
+		n        int
+		expected string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\nc", 1, "c"},
+		{"a\nb\n", 10, "a\nb\n"},
+		{"a\nb\n", 0, ""},
+		{"a\nb\n", -1, "a\nb\n"},
+		{"\n\n\n", 1, "\n"},
+		{"", 3, ""},
+		{"a\n" + long + "\n" + long + "\n", 2, long + "\n" + long + "\n"},
+		{"a\n" + long + "\n" + long, 1, long},
+		{long + "\n" + long, 5, long + "\n" + long},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "log")
+		if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		output, printed := followOutput(t)
+		file := &cmd.FollowedFile{Path: path, Output: output}
+		file.Open(test.n)
+		file.Update()
+
+		if got := printed(); got != test.expected {
+			t.Errorf(
+				"expected the last %d lines of %.20q to be %.20q, but got %.20q",
+				test.n,
+				test.content,
+				test.expected,
+				got,
+			)
+		}
+	}
+}
+
+func TestFollowedFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	write := func(content string, flag int) {
+		f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("old\n", os.O_TRUNC)
+	output, printed := followOutput(t)
+	file := &cmd.FollowedFile{Path: path, Output: output}
+	file.Open(0)
+
+	steps := []struct {
+		name     string
+		change   func()
+		expected string
+	}{
+		{"append", func() { write("one\ntwo\n", os.O_APPEND) }, "one\ntwo\n"},
+		{"unchanged", func() {}, ""},
+		// A copytruncate rotation that grew past the offset in the meantime.
+		{"truncate and grow", func() {
+			write("eleven\ntwelve\n", os.O_TRUNC)
+		}, "eleven\ntwelve\n"},
+		{"truncate", func() { write("3\n", os.O_TRUNC) }, "3\n"},
+		{"rotate", func() {
+			write("four\n", os.O_APPEND)
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+			write("five\n", os.O_TRUNC)
+		}, "four\nfive\n"},
+		{"move away", func() {
+			if err := os.Rename(path, path+".2"); err != nil {
+				t.Fatal(err)
+			}
+		}, ""},
+		{"recreate", func() { write("six\n", os.O_TRUNC) }, "six\n"},
+		{"append again", func() { write("seven\n", os.O_APPEND) }, "seven\n"},
+	}
+
+	for _, step := range steps {
+		step.change()
+		file.Update()
+		if got := printed(); got != step.expected {
+			t.Fatalf(
+				"%s: expected %q, but got %q",
+				step.name,
+				step.expected,
+				got,
+			)
+		}
+	}
+}
+
+func TestFollowedFilePlain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	if err := os.WriteFile(path, []byte("a 1\nb 2\nc 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '\d'
+colors = 'red'
+
+[[rules]]
+regexp = '^b'
+action = 'hide'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	// Without colors, the lines are printed as they are, unless hidden.
+	output := &cmd.Output{Rules: cmdRules.Rules, Out: out, Plain: true}
+	file := &cmd.FollowedFile{Path: path, Output: output}
+	file.Open(-1)
+	file.Update()
+	output.Flush()
+
+	printed, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a 1\nc 3\n"; string(printed) != expected {
+		t.Errorf("expected %q, but got %q", expected, printed)
+	}
+}
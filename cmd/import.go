@@ -0,0 +1,565 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dlclark/regexp2"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ImportOutput string
+	ImportForce  bool
+)
+
+func init() {
+	importGrcCmd.Flags().
+		StringVarP(&ImportOutput, "output", "o", ".", "directory to write config.toml and rules/ to")
+	importGrcCmd.Flags().
+		BoolVar(&ImportForce, "force", false, "overwrite existing rules files and add to an existing config.toml")
+	importCmd.AddCommand(importGrcCmd)
+	rootCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Convert the configuration of other colorizers",
+}
+
+var importGrcCmd = &cobra.Command{
+	Use:   "grc <grc.conf> [conf-dir]",
+	Short: "Convert grc's command map and conf files",
+	Long: `Convert grc's command map and conf files.
+
+Every conf file in grc.conf becomes a rules file in rules/, and an entry of
+config.toml for the commands that use it. Conf files are read from conf-dir, the
+directory of grc.conf by default. Rules that can't be expressed are reported.
+
+With --force, existing rules files are overwritten, and the entries are added to
+an existing config.toml, keeping the commands it already has.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		confDir := filepath.Dir(args[0])
+		if len(args) == 2 {
+			confDir = args[1]
+		}
+
+		report, err := ImportGrc(args[0], confDir, ImportOutput, ImportForce)
+		for _, problem := range report.Problems {
+			cmd.PrintErrln(problem)
+		}
+		if err != nil {
+			return err
+		}
+
+		cmd.Printf(
+			"Imported %d commands with %d rules to %s (%d problems)\n",
+			report.Commands,
+			report.Rules,
+			ImportOutput,
+			len(report.Problems),
+		)
+		return nil
+	},
+}
+
+type (
+	// GrcCommand is an entry of grc.conf: commands matching Regexp are
+	// colorized with the conf file Conf.
+	GrcCommand struct {
+		Regexp string
+		Conf   string
+		Line   int
+	}
+
+	// GrcEntry is an entry of a grc conf file.
+	GrcEntry struct {
+		// Comment is the last comment before the entry.
+		Comment string
+		// Fields are the key=value lines of the entry.
+		Fields map[string]string
+		// Line is the line number where the entry starts.
+		Line int
+	}
+
+	// ImportReport is the result of an import.
+	ImportReport struct {
+		Commands int
+		Rules    int
+		// Problems describe what couldn't be imported.
+		Problems []string
+	}
+)
+
+// ParseGrcConfig parses grc.conf, where every regexp line is followed by the
+// name of its conf file.
+func ParseGrcConfig(content string) []GrcCommand {
+	var commands []GrcCommand
+	var command *GrcCommand
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if command == nil {
+			command = &GrcCommand{Regexp: line, Line: i + 1}
+			continue
+		}
+
+		command.Conf = strings.TrimSpace(line)
+		commands = append(commands, *command)
+		command = nil
+	}
+
+	return commands
+}
+
+// ParseGrcConf parses a grc conf file. Its entries are key=value lines,
+// separated by lines made only of '-', '=' or '.'.
+func ParseGrcConf(content string) []GrcEntry {
+	var entries []GrcEntry
+	var entry *GrcEntry
+	var comment string
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			continue
+
+		case strings.HasPrefix(trimmed, "#"):
+			comment = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			continue
+
+		case strings.Trim(trimmed, "-=.") == "":
+			if entry != nil {
+				entries = append(entries, *entry)
+				entry = nil
+			}
+			comment = ""
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		if entry == nil {
+			entry = &GrcEntry{
+				Comment: comment,
+				Fields:  map[string]string{},
+				Line:    i + 1,
+			}
+		}
+		entry.Fields[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+
+	if entry != nil {
+		entries = append(entries, *entry)
+	}
+
+	return entries
+}
+
+// grcColors maps the colors of grc to styles of ChromaShift.
+var grcColors = map[string]string{
+	"none":      "",
+	"unchanged": "",
+	"default":   "reset",
+	"bold":      "bold",
+	"underline": "underline",
+	"blink":     "blink",
+	"reverse":   "reverse",
+}
+
+// ConvertGrcColors converts the colours of a grc entry. Colors that have no
+// equivalent are dropped and returned as unsupported.
+func ConvertGrcColors(colours string) (string, []string) {
+	var groups []string
+	var unsupported []string
+
+	for group := range strings.SplitSeq(colours, ",") {
+		var styles []string
+		for name := range strings.FieldsSeq(group) {
+			style, ok := grcColor(name)
+			if !ok {
+				unsupported = append(unsupported, name)
+				continue
+			}
+			if style != "" {
+				styles = append(styles, style)
+			}
+		}
+		groups = append(groups, strings.Join(styles, " "))
+	}
+
+	return strings.Join(groups, ","), unsupported
+}
+
+// grcColor converts a single grc color name.
+func grcColor(name string) (string, bool) {
+	if style, ok := grcColors[name]; ok {
+		return style, true
+	}
+
+	prefix := ""
+	if after, ok := strings.CutPrefix(name, "on_"); ok {
+		prefix, name = "bg", after
+	}
+	if after, ok := strings.CutPrefix(name, "bright_"); ok {
+		prefix, name = prefix+"hi", after
+	}
+
+	style := prefix + name
+	if GetColorCode(style) == "" {
+		return "", false
+	}
+	return style, true
+}
+
+// ConvertGrcConf converts the entries of a grc conf file to the content of a
+// rules file. It returns the number of rules and the problems of entries that
+// can't be fully expressed.
+func ConvertGrcConf(entries []GrcEntry) (string, int, []string) {
+	var buf strings.Builder
+	var problems []string
+	rules := 0
+
+	for _, entry := range entries {
+		problem := func(format string, a ...any) {
+			problems = append(
+				problems,
+				fmt.Sprintf("line %d: ", entry.Line)+fmt.Sprintf(format, a...),
+			)
+		}
+
+		expr, ok := entry.Fields["regexp"]
+		if !ok {
+			problem("no regexp, skipped")
+			continue
+		}
+
+		engine := ""
+		groups := 0
+		if re, err := regexp.Compile(expr); err == nil {
+			groups = re.NumSubexp()
+		} else if re, err := regexp2.Compile(expr, regexp2.None); err == nil {
+			engine = "pcre"
+			groups = len(re.GetGroupNumbers()) - 1
+		} else {
+			problem("invalid regexp %q, skipped: %v", expr, err)
+			continue
+		}
+
+		hide := false
+		colors, count := "", ""
+		var replace *string
+		for _, key := range slices.Sorted(maps.Keys(entry.Fields)) {
+			value := strings.TrimSpace(entry.Fields[key])
+			switch key {
+			case "regexp":
+			case "colours", "colors", "colour", "color":
+				var unsupported []string
+				colors, unsupported = ConvertGrcColors(value)
+				if len(unsupported) > 0 {
+					problem(
+						"colours %s are not supported, dropped",
+						strings.Join(unsupported, ", "),
+					)
+				}
+			case "count":
+				switch value {
+				case "more":
+				case "once", "stop", "block", "unblock":
+					count = value
+				default:
+					problem("count=%s is not supported, ignored", value)
+				}
+			case "skip":
+				hide = value == "yes"
+			case "replace":
+				template := ConvertGrcReplace(entry.Fields[key])
+				replace = &template
+			default:
+				problem("%s is not supported, ignored", key)
+			}
+		}
+		// grc doesn't style groups without colours, while rules repeat
+		// their colors for extra groups.
+		if n := strings.Count(colors, ",") + 1; n <= groups {
+			colors += strings.Repeat(",", groups+1-n)
+		}
+
+		if rules > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("[[rules]]")
+		if entry.Comment != "" {
+			buf.WriteString(" # " + entry.Comment)
+		}
+		buf.WriteString("\nregexp = " + tomlString(expr) + "\n")
+		if engine != "" {
+			buf.WriteString("engine = " + tomlString(engine) + "\n")
+		}
+		buf.WriteString("colors = " + tomlString(colors) + "\n")
+		if count != "" {
+			buf.WriteString("count = " + tomlString(count) + "\n")
+		}
+		if hide {
+			buf.WriteString("action = 'hide'\n")
+		}
+		if replace != nil {
+			buf.WriteString("replace = " + tomlString(*replace) + "\n")
+		}
+		rules++
+	}
+
+	return buf.String(), rules, problems
+}
+
+// ConvertGrcReplace converts the replacement of a grc entry, a Python
+// template where \1 or \g<name> is a group, to a template of a replace rule.
+func ConvertGrcReplace(template string) string {
+	var buf strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '$':
+			buf.WriteString("$$")
+		case c != '\\' || i+1 == len(template):
+			buf.WriteByte(c)
+		case template[i+1] >= '0' && template[i+1] <= '9':
+			end := i + 2
+			for end < len(template) && end < i+3 &&
+				template[end] >= '0' && template[end] <= '9' {
+				end++
+			}
+			buf.WriteString("${" + template[i+1:end] + "}")
+			i = end - 1
+		case strings.HasPrefix(template[i+1:], "g<"):
+			name, _, found := strings.Cut(template[i+3:], ">")
+			if !found {
+				buf.WriteByte(c)
+				continue
+			}
+			buf.WriteString("${" + name + "}")
+			i += 3 + len(name)
+		default:
+			buf.WriteByte(template[i+1])
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// ImportGrc converts grc.conf to output/config.toml, and the conf files in
+// confDir it uses to rules files in output/rules. Existing files are only
+// overwritten with force, and config.toml is added to instead.
+func ImportGrc(
+	grcConfig string,
+	confDir string,
+	output string,
+	force bool,
+) (ImportReport, error) {
+	var report ImportReport
+
+	content, err := os.ReadFile(grcConfig)
+	if err != nil {
+		return report, err
+	}
+
+	files := map[string]string{}
+	var order []string
+	// Commands that share a conf file share an entry of config.toml, with
+	// their regexps combined.
+	commands := map[string][]string{}
+	var names []string
+
+	for _, command := range ParseGrcConfig(string(content)) {
+		problem := func(format string, a ...any) {
+			report.Problems = append(
+				report.Problems,
+				fmt.Sprintf("%s:%d: ", grcConfig, command.Line)+
+					fmt.Sprintf(format, a...),
+			)
+		}
+
+		if _, err := regexp.Compile(command.Regexp); err != nil {
+			problem("invalid regexp %q, skipped: %v", command.Regexp, err)
+			continue
+		}
+
+		name := strings.TrimPrefix(filepath.Base(command.Conf), "conf.")
+		rulesFile := filepath.Join("rules", name+".toml")
+
+		if _, ok := files[rulesFile]; !ok {
+			confPath := filepath.Join(confDir, command.Conf)
+			conf, err := os.ReadFile(confPath)
+			if err != nil {
+				problem("%v, skipped", err)
+				continue
+			}
+
+			rules, n, problems := ConvertGrcConf(ParseGrcConf(string(conf)))
+			for _, p := range problems {
+				report.Problems = append(
+					report.Problems,
+					confPath+": "+p,
+				)
+			}
+
+			files[rulesFile] = rules
+			order = append(order, rulesFile)
+			report.Rules += n
+		}
+
+		if _, ok := commands[name]; !ok {
+			names = append(names, name)
+		}
+		commands[name] = append(commands[name], command.Regexp)
+		report.Commands++
+	}
+
+	config, err := importConfig(output, names, commands, force, &report)
+	if err != nil {
+		return report, err
+	}
+	files["config.toml"] = config
+	order = append(order, "config.toml")
+
+	if !force {
+		for _, file := range order {
+			path := filepath.Join(output, file)
+			if _, err := os.Stat(path); err == nil {
+				return report, fmt.Errorf(
+					"%s already exists, use --force to overwrite it",
+					path,
+				)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return report, err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(output, "rules"), 0o755); err != nil {
+		return report, err
+	}
+	for _, file := range order {
+		err := os.WriteFile(
+			filepath.Join(output, file),
+			[]byte(files[file]),
+			0o644,
+		)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// importConfig returns the content of config.toml with an entry for every
+// command name. With force, the entries are added to an existing config.toml,
+// and the commands it already has are kept and reported.
+func importConfig(
+	output string,
+	names []string,
+	commands map[string][]string,
+	force bool,
+	report *ImportReport,
+) (string, error) {
+	var config strings.Builder
+	existing := Config{}
+
+	path := filepath.Join(output, "config.toml")
+	if content, err := os.ReadFile(path); err == nil && force {
+		if _, err := toml.Decode(string(content), &existing); err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		config.Write(content)
+		if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+			config.WriteString("\n")
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := existing[name]; ok {
+			report.Problems = append(
+				report.Problems,
+				fmt.Sprintf("%s: %s is already configured, kept", path, name),
+			)
+			continue
+		}
+
+		regexps := commands[name]
+		expr := regexps[0]
+		if len(regexps) > 1 {
+			expr = "(?:" + strings.Join(regexps, ")|(?:") + ")"
+		}
+
+		if config.Len() > 0 {
+			config.WriteString("\n")
+		}
+		fmt.Fprintf(
+			&config,
+			"[%s]\nregexp = %s\nfile = %s\n",
+			tomlKey(name),
+			tomlString(expr),
+			tomlString(name+".toml"),
+		)
+	}
+
+	return config.String(), nil
+}
+
+// tomlKey quotes the key of a table if it isn't a bare key.
+func tomlKey(key string) string {
+	for _, r := range key {
+		if !(r < unicode.MaxASCII &&
+			(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-')) {
+			return tomlString(key)
+		}
+	}
+	return key
+}
+
+// tomlString quotes s as a TOML string. A literal string is used when
+// possible, like in the rules files, since regexps are full of backslashes.
+func tomlString(s string) string {
+	if !strings.ContainsFunc(s, func(r rune) bool {
+		return r == '\'' || (unicode.IsControl(r) && r != '\t')
+	}) {
+		return "'" + s + "'"
+	}
+
+	w := new(strings.Builder)
+	w.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			w.WriteByte('\\')
+			w.WriteRune(r)
+		case unicode.IsControl(r):
+			fmt.Fprintf(w, "\\u%04X", r)
+		default:
+			w.WriteRune(r)
+		}
+	}
+	w.WriteByte('"')
+	return w.String()
+}
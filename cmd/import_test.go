@@ -0,0 +1,203 @@
+package cmd_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+
+	"cshift/cmd"
+)
+
+const grcConfig = `# ping
+(^|[/\w\.]+/)ping\s
+conf.ping
+
+# ping6
+(^|[/\w\.]+/)ping6\s
+conf.ping
+
+# broken
+(^|[/\w\.]+/)(?<=x)y\s
+conf.broken
+`
+
+const grcPing = `# statistics
+regexp=(\d+) packets transmitted, (\d+) received
+colours=default,bold bright_green,on_red italic
+count=once
+-
+# lookbehind
+regexp=(?<=time=)[\d.]+
+colours=yellow
+count=previous
+=======
+regexp=^PING
+skip=yes
+-
+# time unit
+regexp=(\d+) ?ms
+replace=\1 milliseconds ($)
+`
+
+func TestImportGrc(t *testing.T) {
+	dir := t.TempDir()
+	grcConf := filepath.Join(dir, "grc.conf")
+	if err := os.WriteFile(grcConf, []byte(grcConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(dir, "conf.ping"), []byte(grcPing), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out")
+	report, err := cmd.ImportGrc(grcConf, dir, output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Commands != 2 || report.Rules != 4 {
+		t.Errorf("expected 2 commands with 4 rules, but got %+v", report)
+	}
+
+	problems := strings.Join(report.Problems, "\n")
+	for _, expected := range []string{
+		"italic", "count=previous", "grc.conf:10: invalid regexp",
+	} {
+		if !strings.Contains(problems, expected) {
+			t.Errorf(
+				"expected a problem with %q, but got:\n%s",
+				expected,
+				problems,
+			)
+		}
+	}
+
+	var config cmd.Config
+	_, err = toml.DecodeFile(filepath.Join(output, "config.toml"), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["ping"].File != "ping.toml" {
+		t.Fatalf("expected ping.toml for ping, but got %+v", config)
+	}
+	for _, args := range [][]string{{"ping", "host"}, {"ping6", "host"}} {
+		file, err := cmd.GetRuleFileName(config, args)
+		if err != nil || file != "ping.toml" {
+			t.Errorf("expected ping.toml for %s, but got %q", args[0], file)
+		}
+	}
+
+	cmdRules, err := cmd.LoadRulesFile(filepath.Join(output, "rules/ping.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := cmdRules.Rules
+	if rules[0].Colors != "reset,bold higreen,bgred" ||
+		rules[0].Count != "once" {
+		t.Errorf("unexpected colors or count: %+v", rules[0])
+	}
+	if rules[1].Engine != "pcre" || rules[1].Colors != "yellow" {
+		t.Errorf("expected a pcre rule, but got %+v", rules[1])
+	}
+	if rules[2].Action != "hide" {
+		t.Errorf("expected a hide rule, but got %+v", rules[2])
+	}
+	if rules[3].Replace == nil ||
+		*rules[3].Replace != "${1} milliseconds ($$)" {
+		t.Errorf("expected a replace rule, but got %+v", rules[3])
+	}
+
+	if _, err := cmd.ImportGrc(grcConf, dir, output, false); err == nil {
+		t.Fatal("expected an error for existing files")
+	}
+}
+
+func TestImportGrcForce(t *testing.T) {
+	dir := t.TempDir()
+	grcConf := filepath.Join(dir, "grc.conf")
+	if err := os.WriteFile(grcConf, []byte(grcConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(dir, "conf.ping"), []byte(grcPing), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out")
+	if err := os.Mkdir(output, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	existing := "# mine\n[ls]\nfile = 'ls.toml'"
+	configPath := filepath.Join(output, "config.toml")
+	if err := os.WriteFile(configPath, []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first import adds ping, and the second one keeps it.
+	var content []byte
+	for i := range 2 {
+		report, err := cmd.ImportGrc(grcConf, dir, output, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reported := strings.Contains(
+			strings.Join(report.Problems, "\n"),
+			"ping is already configured",
+		)
+		if reported != (i == 1) {
+			t.Errorf("import %d: unexpected problems %q", i, report.Problems)
+		}
+
+		imported, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && !bytes.Equal(imported, content) {
+			t.Errorf("expected the config unchanged, but got:\n%s", imported)
+		}
+		content = imported
+	}
+
+	if !strings.HasPrefix(string(content), existing+"\n\n[ping]") {
+		t.Errorf(
+			"expected ping after the existing config, but got:\n%s",
+			content,
+		)
+	}
+
+	var config cmd.Config
+	if _, err := toml.Decode(string(content), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["ls"].File != "ls.toml" || config["ping"].File != "ping.toml" {
+		t.Errorf("expected ls and ping, but got %+v", config)
+	}
+}
+
+func TestParseGrcConf(t *testing.T) {
+	entries := cmd.ParseGrcConf(`regexp=one
+.colours=red
+--------
+regexp=two
+=
+regexp=three
+`)
+
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, but got %+v", entries)
+	}
+	if entries[0].Fields[".colours"] != "red" {
+		t.Errorf("expected a field starting with '.', but got %+v", entries[0])
+	}
+	for i, regexp := range []string{"one", "two", "three"} {
+		if entries[i].Fields["regexp"] != regexp {
+			t.Errorf("expected %q, but got %+v", regexp, entries[i])
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mapping styles the captures of a rule that are Value, or that contain a
+// match of Regexp, with Colors.
+type Mapping struct {
+	Value  string  `toml:"value"`
+	Regexp *Regexp `toml:"regexp"`
+	Colors string  `toml:"colors"`
+
+	codes []string
+}
+
+// prepareMap checks and compiles the map of a rule. Its regexps use the engine
+// and flags of the rule.
+func (r *Rule) prepareMap() error {
+	for i := range r.Map {
+		mapping := &r.Map[i]
+		if (mapping.Value == "") == (mapping.Regexp == nil) {
+			return fmt.Errorf("map %d needs either value or regexp", i+1)
+		}
+
+		if mapping.Regexp != nil {
+			if err := mapping.Regexp.Compile(r.Engine, r.Flags); err != nil {
+				return fmt.Errorf("map %d: %w", i+1, err)
+			}
+		}
+		mapping.codes = styleCodes(mapping.Colors)
+	}
+	return nil
+}
+
+// mapStyle returns the style of the first mapping of the capture, and whether
+// one applies.
+func (r *Rule) mapStyle(capture string) ([]string, bool) {
+	fold := strings.ContainsRune(r.Flags, 'i')
+	for _, mapping := range r.Map {
+		var matched bool
+		switch {
+		case mapping.Regexp != nil:
+			matched = mapping.Regexp.MatchString(capture)
+		case fold:
+			matched = strings.EqualFold(mapping.Value, capture)
+		default:
+			matched = mapping.Value == capture
+		}
+		if matched {
+			return mapping.codes, true
+		}
+	}
+	return nil, false
+}
+
+// styleCodes returns the codes of all the styles in colors, for the fields
+// that style a single capture.
+func styleCodes(colors string) []string {
+	var codes []string
+	for _, style := range ParseStyles(colors) {
+		codes = append(codes, style.Codes...)
+	}
+	return codes
+}
@@ -0,0 +1,41 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestColorizeMap(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '^(\w+)'
+flags = 'i'
+colors = ',blue'
+map = [
+  { value = 'error', colors = 'red' },
+  { regexp = '^warn', colors = 'yellow' },
+]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "ERROR disk full\nwarning: low memory\ninfo: started\n"
+	expected := "> \x1b[34;31mERROR\x1b[0m disk full\x1b[0m\n" +
+		"> \x1b[34;33mwarning\x1b[0m: low memory\x1b[0m\n" +
+		"> \x1b[34minfo\x1b[0m: started\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+
+	_, err = cmd.DecodeRules(`
+[[rules]]
+regexp = '(\w+)'
+map = [{ colors = 'red' }]
+`)
+	if err == nil {
+		t.Error("expected an error for a mapping without value or regexp")
+	}
+}
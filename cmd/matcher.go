@@ -0,0 +1,370 @@
+package cmd
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLiterals limits the number of alternative literals of a rule. Rules that
+// need more are always run.
+const maxLiterals = 32
+
+// Matcher colorizes lines like Colorize, but only runs the rules that can
+// match a line. Literals that every match of a rule contains are searched in
+// a single pass over the line, and rules whose literals are missing are
+// skipped.
+type Matcher struct {
+	Rules []Rule
+
+	// filtered tells which rules have literals. The others are always run.
+	filtered []bool
+	// fold tells which rules have case-insensitive literals, which are only
+	// searched in lines without non-ASCII bytes.
+	fold      []bool
+	automaton automaton
+	stateful  bool
+	// filters are the rules that decide which lines are printed.
+	filters []*Rule
+}
+
+// NewMatcher extracts the literals of the rules and builds a Matcher.
+func NewMatcher(rules []Rule) *Matcher {
+	m := &Matcher{
+		Rules:    rules,
+		filtered: make([]bool, len(rules)),
+		fold:     make([]bool, len(rules)),
+	}
+
+	var patterns []string
+	var owners []int
+	for i, rule := range rules {
+		switch {
+		case rule.State != nil, rule.Start != nil, rule.Table != nil:
+			m.stateful = true
+		case rule.Count == "block", rule.Count == "unblock":
+			m.stateful = true
+		}
+		if rule.Filters() {
+			m.filters = append(m.filters, &rules[i])
+		}
+
+		// Only RE2 syntax can be parsed for literals.
+		if rule.Regexp == nil || rule.Regexp.RE2() == nil {
+			continue
+		}
+
+		re, err := syntax.Parse(rule.Regexp.RE2().String(), syntax.Perl)
+		if err != nil {
+			continue
+		}
+
+		lits, fold := literals(re)
+		if lits == nil {
+			continue
+		}
+
+		m.filtered[i] = true
+		m.fold[i] = fold
+		for _, lit := range lits {
+			patterns = append(patterns, lit)
+			owners = append(owners, i)
+		}
+	}
+
+	m.automaton = newAutomaton(patterns, owners)
+	return m
+}
+
+// Candidates returns which rules can match the line.
+func (m *Matcher) Candidates(line string) []bool {
+	candidates := make([]bool, len(m.Rules))
+	ascii := m.automaton.scan(line, func(rule int) {
+		candidates[rule] = true
+	})
+
+	for i, filtered := range m.filtered {
+		if !filtered || (m.fold[i] && !ascii) {
+			candidates[i] = true
+		}
+	}
+	return candidates
+}
+
+// Colorize styles the parts of the line matched by the rules.
+func (m *Matcher) Colorize(line string) string {
+	return m.ColorizeFrom(line, 0)
+}
+
+// ColorizeFrom colorizes the line like the ColorizeFrom function.
+func (m *Matcher) ColorizeFrom(line string, from int) string {
+	colored, _ := m.ColorizeLine(line, from, LineState{})
+	return colored
+}
+
+// ColorizeLine colorizes the line like ColorizeFrom, in the state left by the
+// previous line, and returns the state for the next line.
+func (m *Matcher) ColorizeLine(
+	line string,
+	from int,
+	state LineState,
+) (string, LineState) {
+	return colorize(line, m.Rules, m.Candidates(line), from, state, nil)
+}
+
+// Annotate colorizes the line like ColorizeLine, and also returns the spans
+// that the rules styled in it, in the order they were styled. A line that the
+// rules hide gets a "hide" span over all of it.
+func (m *Matcher) Annotate(
+	line string,
+	state LineState,
+) (string, []Span, LineState) {
+	var notes annotation
+	colored, state := colorize(
+		line,
+		m.Rules,
+		m.Candidates(line),
+		0,
+		state,
+		&notes,
+	)
+
+	if hidden, rule := m.hide(line); hidden {
+		notes.add(rule, "hide", 0, 0, len(line), nil)
+		notes[len(notes)-1].Text = line
+	}
+	return colored, notes, state
+}
+
+// Keep reports whether the line is printed: it matches no hide rule, and one
+// of the keep-only rules if there are any.
+func (m *Matcher) Keep(line string) bool {
+	hidden, _ := m.hide(line)
+	return !hidden
+}
+
+// hide reports whether the rules hide the line, and the hide rule that hides
+// it, or nil if it matches none of the keep-only rules.
+func (m *Matcher) hide(line string) (bool, *Rule) {
+	only, kept := false, false
+	for _, rule := range m.filters {
+		matched := rule.Regexp.MatchString(line)
+		switch rule.Action {
+		case "hide":
+			if matched {
+				return true, rule
+			}
+		case "keep-only":
+			only = true
+			kept = kept || matched
+		}
+	}
+	return only && !kept, nil
+}
+
+// Filters reports whether the rules hide any lines.
+func (m *Matcher) Filters() bool {
+	return len(m.filters) > 0
+}
+
+// Stateful reports whether the rules carry state from one line to the next,
+// so lines must be colorized in order.
+func (m *Matcher) Stateful() bool {
+	return m.stateful
+}
+
+// literals returns strings of which every match of re contains at least one,
+// or nil if there are none. fold tells whether the strings were matched
+// case-insensitively.
+func literals(re *syntax.Regexp) (lits []string, fold bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		s := string(re.Rune)
+		// Invalid UTF-8 in a line matches U+FFFD.
+		if strings.ContainsRune(s, utf8.RuneError) {
+			return nil, false
+		}
+
+		if re.Flags&syntax.FoldCase != 0 {
+			// The automaton only folds ASCII letters.
+			for _, r := range re.Rune {
+				if r >= utf8.RuneSelf {
+					return nil, false
+				}
+			}
+			return []string{s}, true
+		}
+		return []string{s}, false
+
+	case syntax.OpCharClass:
+		// Small classes like [eE] are a few alternative literals.
+		n := 0
+		for i := 0; i < len(re.Rune); i += 2 {
+			n += int(re.Rune[i+1]-re.Rune[i]) + 1
+			if n > 4 {
+				return nil, false
+			}
+		}
+
+		for i := 0; i < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if r == utf8.RuneError {
+					return nil, false
+				}
+				lits = append(lits, string(r))
+			}
+		}
+		return lits, false
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return literals(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return literals(re.Sub[0])
+		}
+
+	case syntax.OpConcat:
+		// Every part is matched, so the part with the most selective
+		// literals is used.
+		for _, sub := range re.Sub {
+			subLits, subFold := literals(sub)
+			if subLits != nil && better(subLits, lits) {
+				lits, fold = subLits, subFold
+			}
+		}
+		return lits, fold
+
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			subLits, subFold := literals(sub)
+			if subLits == nil || len(lits)+len(subLits) > maxLiterals {
+				return nil, false
+			}
+			lits = append(lits, subLits...)
+			fold = fold || subFold
+		}
+		return lits, fold
+	}
+
+	return nil, false
+}
+
+// better tells whether the literals a are more selective than b: their
+// shortest literal is longer, or they are fewer.
+func better(a, b []string) bool {
+	if b == nil {
+		return true
+	}
+
+	shortest := func(lits []string) int {
+		n := len(lits[0])
+		for _, lit := range lits[1:] {
+			n = min(n, len(lit))
+		}
+		return n
+	}
+
+	if shortest(a) != shortest(b) {
+		return shortest(a) > shortest(b)
+	}
+	return len(a) < len(b)
+}
+
+// automaton is an Aho-Corasick automaton that finds the rules of all
+// literals contained in a line, ignoring the case of ASCII letters.
+type automaton struct {
+	// next is the transition table, with 256 entries per state.
+	next []int32
+	// rules lists the rules with a literal that ends in each state.
+	rules [][]int
+}
+
+// newAutomaton builds an automaton for the patterns, where owners[i] is the
+// rule of patterns[i].
+func newAutomaton(patterns []string, owners []int) automaton {
+	a := automaton{next: make([]int32, 256), rules: make([][]int, 1)}
+
+	// Build the trie; 0 is the root and missing transitions.
+	for i, pattern := range patterns {
+		state := int32(0)
+		for j := range len(pattern) {
+			idx := int(state)*256 + int(lower(pattern[j]))
+			if a.next[idx] == 0 {
+				a.next[idx] = int32(len(a.rules))
+				a.next = append(a.next, make([]int32, 256)...)
+				a.rules = append(a.rules, nil)
+			}
+			state = a.next[idx]
+		}
+		a.rules[state] = appendRule(a.rules[state], owners[i])
+	}
+
+	// Add the failure transitions breadth first, so that the transitions
+	// of the failure state of each state are complete.
+	fail := make([]int32, len(a.rules))
+	var queue []int32
+	for b := range 256 {
+		if s := a.next[b]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for _, rule := range a.rules[fail[state]] {
+			a.rules[state] = appendRule(a.rules[state], rule)
+		}
+
+		for b := range 256 {
+			idx := int(state)*256 + b
+			failNext := a.next[int(fail[state])*256+b]
+			if s := a.next[idx]; s != 0 {
+				fail[s] = failNext
+				queue = append(queue, s)
+			} else {
+				a.next[idx] = failNext
+			}
+		}
+	}
+
+	return a
+}
+
+// scan calls found for the rules of the literals in s, possibly more than
+// once. It returns whether s only contains ASCII bytes.
+func (a *automaton) scan(s string, found func(rule int)) (ascii bool) {
+	ascii = true
+	state := int32(0)
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+		}
+
+		state = a.next[int(state)*256+int(lower(s[i]))]
+		for _, rule := range a.rules[state] {
+			found(rule)
+		}
+	}
+	return ascii
+}
+
+// appendRule adds the rule to the rules if it isn't already there.
+func appendRule(rules []int, rule int) []int {
+	for _, r := range rules {
+		if r == rule {
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// lower returns the lowercase of an ASCII letter, and other bytes unchanged.
+func lower(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
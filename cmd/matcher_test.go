@@ -0,0 +1,167 @@
+package cmd_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cshift/cmd"
+)
+
+// corpus is output of the commands with rules files.
+var corpus = strings.Split(`PING 1.1.1.1 (1.1.1.1) 56(84) bytes of data.
+64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=10.2 ms
+From 10.0.0.1 icmp_seq=2 Destination Host Unreachable
+--- 1.1.1.1 ping statistics ---
+2 packets transmitted, 1 received, 50% packet loss, time 1001ms
+rtt min/avg/max/mdev = 10.213/10.213/10.213/0.000 ms
+execve("/usr/bin/ls", ["ls"], 0x7ffd4a6a1b40 /* 42 vars */) = 0
+openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+read(3, "\177ELF\2\1\1\0\0\0\0\0\0\0\0\0\3\0>\0\1\0\0\0"..., 832) = 832
+[pid  4242] close(3)                    = 0
+[pid  4242] wait4(-1, 0x7ffc, 0, NULL) = -1 ECHILD (No child processes)
+write(1, "hello\n", 6)                  = 6
++++ exited with 0 +++
+--- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED} ---
+Filesystem      Size  Used Avail Use% Mounted on
+/dev/nvme0n1p2  468G  321G  124G  73% /
+tmpfs           7.8G  1.2M  7.8G   1% /run
+               total        used        free      shared  buff/cache   available
+Mem:           15Gi       7.1Gi       1.9Gi       1.0Gi       7.4Gi       7.0Gi
+Swap:         8.0Gi          0B       8.0Gi
+--- PASS: TestColorize (0.00s)
+--- FAIL: TestOutput (0.01s)
+    output_test.go:42: expected "a", but got "b"
+=== RUN   TestMatcher
+ok  	cshift/cmd	0.007s
+FAIL	cshift/cmd	0.012s
+CONTAINER ID   IMAGE          COMMAND                  CREATED        STATUS                    PORTS                    NAMES
+3f2a1b0c9d8e   nginx:latest   "/docker-entrypoint.…"   2 hours ago    Up 2 hours (healthy)      0.0.0.0:80->80/tcp       web
+a1b2c3d4e5f6   redis:7        "docker-entrypoint.s…"   3 days ago     Exited (137) 2 days ago                            cache
+REPOSITORY   TAG       IMAGE ID       CREATED        SIZE
+golang       1.24      0123456789ab   3 weeks ago    838MB
+NETWORK ID     NAME      DRIVER    SCOPE
+6f1e2d3c4b5a   bridge    bridge    local
+USER         PID %CPU %MEM    VSZ   RSS TTY      STAT START   TIME COMMAND
+root           1  0.0  0.1 168432 12345 ?        Ss   09:12   0:03 /sbin/init
+user      123456  12.5  3.4 2345678 567890 pts/1 Rl+  10:01   1:23 go test ./...
+Proto Recv-Q Send-Q Local Address           Foreign Address         State
+tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN
+tcp6       0      0 ::1:631                 :::*                    LISTEN
+udp        0      0 127.0.0.53:53           0.0.0.0:*
+NAME        MAJ:MIN RM   SIZE RO TYPE MOUNTPOINTS
+nvme0n1     259:0    0 476.9G  0 disk
+├─nvme0n1p1 259:1    0   512M  0 part /boot
+/dev/nvme0n1p2 on / type ext4 (rw,relatime)
+proc on /proc type proc (rw,nosuid,nodev,noexec,relatime)
+uid=1000(user) gid=1000(user) groups=1000(user),998(wheel),965(docker)
+user     pts/0        192.168.1.10     Mon Oct 19 09:00   still logged in
+reboot   system boot  6.18.44-fc-v139  Mon Oct 19 08:59   still running
+Architecture:             x86_64
+  CPU op-mode(s):         32-bit, 64-bit
+Model name:               AMD Ryzen 7 5800X 8-Core Processor
+Module                  Size  Used by
+nvidia_drm            118784  4
+procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu-----
+ r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st
+ 1  0      0 1987652 123456 7654321    0    0     3    10  120  250  5  1 94  0  0
+traceroute to example.com (93.184.215.14), 30 hops max, 60 byte packets
+ 1  _gateway (192.168.1.1)  0.512 ms  0.498 ms  0.487 ms
+ 2  * * *
+4.0K	./cmd/exit.go
+1.2M	./rules
+'a.txt' -> 'b.txt'
+renamed 'old' -> 'new'
+removed 'file.txt'
+removed directory 'dir'
+  File: go.mod
+  Size: 1234      	Blocks: 8          IO Block: 4096   regular file
+Access: (0644/-rw-r--r--)  Uid: ( 1000/    user)   Gid: ( 1000/    user)
+Modify: 2026-10-19 09:12:34.567890123 +0000
+LINK: .bashrc => ../dotfiles/bash/.bashrc
+main.go:12:5: error: expected ';' before '}' token
+main.c:3:10: warning: unused variable 'x' [-Wunused-variable]
+main.c: In function 'main':
+;; ANSWER SECTION:
+example.com.		3600	IN	A	93.184.215.14
+;; Query time: 12 msec
+HTTP/2 200
+content-type: text/html; charset=UTF-8
+  % Total    % Received % Xferd  Average Speed   Time    Time     Time  Current
+100  1256  100  1256    0     0  12345      0 --:--:-- --:--:-- --:--:-- 12345
+--2026-10-19 09:12:34--  https://example.com/
+Resolving example.com (example.com)... 93.184.215.14
+HTTP request sent, awaiting response... 200 OK
+Saving to: ‘index.html’
+[youtube] Extracting URL: https://www.youtube.com/watch?v=dQw4w9WgXcQ
+[download]  45.3% of   12.34MiB at    2.34MiB/s ETA 00:03
+PATH=/usr/local/bin:/usr/bin:/bin
+HOME=/home/user
+./cmd/colorize.go
+/usr/share/doc
+HEAD IS NOW AT 1f75819
+Ärger café ſtraße KELVIN
+caf`+"\xe9 \xff\xfe from \xc3", "\n")
+
+// ruleFiles returns the paths of the embedded rules files.
+func ruleFiles(tb testing.TB) []string {
+	paths, err := filepath.Glob("../rules/*.toml")
+	if err != nil || len(paths) == 0 {
+		tb.Fatal("no rules files", err)
+	}
+	return paths
+}
+
+func TestMatcher(t *testing.T) {
+	for _, path := range ruleFiles(t) {
+		cmdRules, err := cmd.LoadRulesFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules := cmdRules.AllRules()
+		matcher := cmd.NewMatcher(rules)
+
+		for _, line := range corpus {
+			expected := cmd.Colorize(line, rules)
+			if colored := matcher.Colorize(line); colored != expected {
+				t.Errorf(
+					"%s: expected %q for %q, but got %q",
+					path, expected, line, colored,
+				)
+			}
+		}
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	input := strings.Repeat(strings.Join(corpus, "\n")+"\n", 10)
+	lines := strings.Split(input, "\n")
+
+	for _, path := range ruleFiles(b) {
+		cmdRules, err := cmd.LoadRulesFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rules := cmdRules.AllRules()
+		matcher := cmd.NewMatcher(rules)
+		name := strings.TrimSuffix(filepath.Base(path), ".toml")
+
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				for _, line := range lines {
+					_ = matcher.Colorize(line)
+				}
+			}
+		})
+
+		b.Run(name+"/each", func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				for _, line := range lines {
+					_ = cmd.Colorize(line, rules)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Quantity is a number with an optional unit, like 90, '1.5G' or '20ms'.
+	Quantity float64
+
+	// Threshold styles the numbers from Value up to the next threshold.
+	Threshold struct {
+		Value  Quantity `toml:"value"`
+		Colors string   `toml:"colors"`
+
+		codes []string
+	}
+
+	// Stop is the color of a gradient at Value, like '#ff0000'.
+	Stop struct {
+		Value Quantity `toml:"value"`
+		Color string   `toml:"color"`
+
+		rgb [3]float64
+	}
+)
+
+// units are the multipliers of the units of numbers. Sizes are binary, like
+// in the output of df -h or ls -lh.
+var units = map[string]float64{
+	"":   1,
+	"%":  1,
+	"B":  1,
+	"K":  1 << 10,
+	"M":  1 << 20,
+	"G":  1 << 30,
+	"T":  1 << 40,
+	"P":  1 << 50,
+	"ns": 1e-9,
+	"us": 1e-6,
+	"µs": 1e-6,
+	"ms": 1e-3,
+	"s":  1,
+}
+
+// ParseNumber parses a number with an optional unit. Sizes may end with i or
+// B, like 1.5Gi or 10KB, and a comma may be the decimal separator.
+func ParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+
+	end := 0
+	for end < len(s) && strings.IndexByte("+-0123456789.,", s[end]) >= 0 {
+		end++
+	}
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(s[:end], ",", "."), 64)
+	if err != nil {
+		return 0, false
+	}
+
+	unit := strings.TrimSpace(s[end:])
+	if len(unit) > 1 && strings.ContainsAny(unit[:1], "KMGTP") {
+		unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "i")
+	}
+
+	multiplier, ok := units[unit]
+	if !ok {
+		return 0, false
+	}
+	return value * multiplier, true
+}
+
+// UnmarshalTOML decodes a number, or a string with a unit.
+func (q *Quantity) UnmarshalTOML(value any) error {
+	switch value := value.(type) {
+	case int64:
+		*q = Quantity(value)
+	case float64:
+		*q = Quantity(value)
+	case string:
+		number, ok := ParseNumber(value)
+		if !ok {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*q = Quantity(number)
+	default:
+		return fmt.Errorf("invalid number %v", value)
+	}
+	return nil
+}
+
+// prepareNumbers sorts and parses the thresholds and the gradient of a rule.
+func (r *Rule) prepareNumbers() error {
+	if len(r.Thresholds) > 0 && len(r.Gradient) > 0 {
+		return fmt.Errorf("a rule has either thresholds or a gradient")
+	}
+
+	slices.SortStableFunc(r.Thresholds, func(a, b Threshold) int {
+		return compareQuantities(a.Value, b.Value)
+	})
+	for i := range r.Thresholds {
+		r.Thresholds[i].codes = styleCodes(r.Thresholds[i].Colors)
+	}
+
+	slices.SortStableFunc(r.Gradient, func(a, b Stop) int {
+		return compareQuantities(a.Value, b.Value)
+	})
+	for i := range r.Gradient {
+		stop := &r.Gradient[i]
+		rgb, ok := ParseHexColor(stop.Color)
+		if !ok {
+			return fmt.Errorf("invalid gradient color %q", stop.Color)
+		}
+		stop.rgb = [3]float64{float64(rgb[0]), float64(rgb[1]), float64(rgb[2])}
+	}
+
+	return nil
+}
+
+func compareQuantities(a, b Quantity) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// valueStyle returns the style of a captured value: the style of its mapping,
+// or the style of the number in it.
+func (r *Rule) valueStyle(value string) []string {
+	if codes, ok := r.mapStyle(value); ok {
+		return codes
+	}
+	if len(r.Thresholds) == 0 && len(r.Gradient) == 0 {
+		return nil
+	}
+	return r.numberStyle(value)
+}
+
+// numberStyle returns the style of the number in text, from the thresholds or
+// the gradient of the rule.
+func (r *Rule) numberStyle(text string) []string {
+	value, ok := ParseNumber(text)
+	if !ok {
+		return nil
+	}
+
+	if len(r.Thresholds) > 0 {
+		var codes []string
+		for _, threshold := range r.Thresholds {
+			if value < float64(threshold.Value) {
+				break
+			}
+			codes = threshold.codes
+		}
+		return codes
+	}
+
+	// Interpolate between the stops around the value.
+	i := 0
+	for i < len(r.Gradient)-1 && value > float64(r.Gradient[i+1].Value) {
+		i++
+	}
+	from := r.Gradient[i]
+	to := r.Gradient[min(i+1, len(r.Gradient)-1)]
+
+	t := 0.0
+	if to.Value != from.Value {
+		t = (value - float64(from.Value)) / float64(to.Value-from.Value)
+		t = max(0, min(1, t))
+	}
+
+	var rgb [3]uint8
+	for c := range rgb {
+		rgb[c] = uint8(math.Round(from.rgb[c] + t*(to.rgb[c]-from.rgb[c])))
+	}
+
+	color := PaletteColor(
+		fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]),
+	)
+	if color == "" {
+		return nil
+	}
+	return []string{color}
+}
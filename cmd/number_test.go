@@ -0,0 +1,71 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestParseNumber(t *testing.T) {
+	for s, expected := range map[string]float64{
+		"85%":   85,
+		"1,5G":  1.5 * (1 << 30),
+		"2Gi":   2 * (1 << 30),
+		"10KB":  10 * (1 << 10),
+		"250ms": 0.25,
+		"-3":    -3,
+	} {
+		if number, ok := cmd.ParseNumber(s); !ok || number != expected {
+			t.Errorf("expected %v for %q, but got %v", expected, s, number)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "10 apples", "1.2.3"} {
+		if _, ok := cmd.ParseNumber(s); ok {
+			t.Errorf("expected no number in %q", s)
+		}
+	}
+}
+
+func TestColorizeNumbers(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = 'coverage: (\S+)'
+thresholds = [
+  { value = 80, colors = 'green' },
+  { value = 50, colors = 'yellow' },
+]
+
+[[rules]]
+regexp = '\d+ms'
+gradient = [
+  { value = '10ms', color = '#00ff00' },
+  { value = '1s', color = '#ff0000' },
+]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "coverage: 85.0% in 505ms\n" +
+		"coverage: 60.0% in 5ms\n" +
+		"coverage: 10.0% in 2000ms\n"
+	expected := "> coverage: \x1b[32m85.0%\x1b[0m in " +
+		"\x1b[38;2;128;128;0m505ms\x1b[0m\x1b[0m\n" +
+		"> coverage: \x1b[33m60.0%\x1b[0m in " +
+		"\x1b[38;2;0;255;0m5ms\x1b[0m\x1b[0m\n" +
+		"> coverage: 10.0% in \x1b[38;2;255;0;0m2000ms\x1b[0m\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+
+	_, err = cmd.DecodeRules(`
+[[rules]]
+regexp = '\d+'
+gradient = [{ value = 0, color = 'red' }]
+`)
+	if err == nil {
+		t.Error("expected an error for an invalid gradient color")
+	}
+}
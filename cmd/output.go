@@ -3,18 +3,21 @@ package cmd
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
 )
 
 type Output struct {
@@ -22,55 +25,396 @@ type Output struct {
 	Out     *os.File
 	Buffer  bytes.Buffer
 	Rules   []Rule
+
+	// Forwarder relays signals to the command once it is started.
+	Forwarder *Forwarder
+
+	// Prefix is printed before every line.
+	Prefix string
+
+	// Interactive makes every line printed as soon as it's complete. Otherwise
+	// output is buffered until everything read so far is colorized.
+	Interactive bool
+
+	// Plain prints the lines as they are, only leaving out the lines that
+	// the rules hide.
+	Plain bool
+
+	writer  *bufio.Writer
+	matcher *Matcher
+	state   LineState
+	// pipeline colorizes complete lines in parallel. It's stopped before
+	// anything else is printed.
+	pipeline *pipeline
+	// tty tells whether Out is a terminal, once it's known.
+	tty    *bool
+	escape escapeParser
+	screen screen
+
+	// mu guards the buffer against the idle flush.
+	mu sync.Mutex
+	// timer prints a partial line after IdleFlush, and flushed is the length
+	// of the partial line already printed.
+	timer   *time.Timer
+	flushed int
+	// idleSince is when all output read so far was last written.
+	idleSince time.Time
 }
 
+// maxPending is the number of lines that can wait in the pipeline before
+// reading more output blocks.
+const maxPending = 1024
+
 func NewOutput(cmd *exec.Cmd, rules []Rule, strerr bool) *Output {
-	var out *os.File
-	if strerr {
-		out = os.Stderr
-	} else {
-		out = os.Stdout
-	}
-	output := Output{Command: cmd, Rules: rules, Out: out}
+	output := Output{Command: cmd, Rules: rules, Out: outputFile(strerr)}
 	return &output
 }
 
-func (o *Output) Write(char rune) {
-	if char == '\r' {
-		line := o.Buffer.String()
-		coloredLine := Colorize(line, o.Rules)
-		if len(coloredLine) > 0 {
-			fmt.Fprint(o.Out, coloredLine+"\r")
-		} else {
-			fmt.Fprint(o.Out, line+"\r")
+// outputFile returns the file cshift writes the stream of the command to.
+func outputFile(stderr bool) *os.File {
+	if stderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// colorable reports whether output written to f should be colorized.
+func colorable(f *os.File) bool {
+	return Color == "always" || isTerminal(f)
+}
+
+// Write colorizes and prints the lines in p. It accepts any bytes: data that
+// isn't valid UTF-8 is printed unchanged.
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		if o.escape.Active() || o.screen.Raw() {
+			o.writeByte(p[0])
+			p = p[1:]
+			continue
 		}
-		o.Buffer.Reset()
-	} else {
-		runeBytes := make([]byte, utf8.RuneLen(char))
-		utf8.EncodeRune(runeBytes, char)
-		for _, b := range runeBytes {
+
+		// Only line ends and escape sequences need a closer look, so the
+		// rest of the line is buffered at once.
+		i := bytes.IndexAny(p, "\n\r\x1b")
+		if i < 0 {
+			o.Buffer.Write(p)
+			break
+		}
+
+		o.Buffer.Write(p[:i])
+		o.writeByte(p[i])
+		p = p[i+1:]
+	}
+
+	o.idle()
+	return n, nil
+}
+
+func (o *Output) writeByte(b byte) {
+	seq := o.escape.Feed(b)
+	if seq != nil && !o.screen.Raw() && cursorUp(seq) {
+		o.redraw(len(seq))
+		return
+	}
+	if seq != nil || o.screen.Raw() {
+		wasRaw := o.screen.Raw()
+		if seq != nil {
+			o.screen.Update(seq)
+		}
+
+		if wasRaw || o.screen.Raw() {
 			o.Buffer.WriteByte(b)
+			if b == '\n' {
+				o.screen.EndLine()
+			}
+			if !o.screen.Raw() {
+				o.FlushRaw()
+			}
+			return
 		}
 	}
 
-	if char == '\n' {
+	if b == '\r' {
+		o.writeLine(o.Buffer.String(), "\r")
+	} else {
+		o.Buffer.WriteByte(b)
+	}
+
+	if b == '\n' {
 		line := strings.TrimRightFunc(o.Buffer.String(), unicode.IsSpace)
-		coloredLine := Colorize(line, o.Rules)
-		if len(coloredLine) > 0 {
-			fmt.Fprint(o.Out, coloredLine+"\n")
-		} else {
-			fmt.Fprint(o.Out, line+"\n")
+		o.writeLine(line, "\n")
+	}
+}
+
+// redraw prints the cursor-up sequence of length n at the end of the buffer,
+// after the line before it. The line it goes up to is printed next.
+func (o *Output) redraw(n int) {
+	// The last byte of the sequence isn't buffered yet.
+	seq := slices.Clone(o.Buffer.Bytes()[o.Buffer.Len()-n+1:])
+	o.Buffer.Truncate(o.Buffer.Len() - n + 1)
+	seq = append(seq, 'A')
+
+	if o.Buffer.Len() > o.flushed {
+		o.writeLine(o.Buffer.String(), "")
+	}
+	o.flushed = 0
+	o.Buffer.Reset()
+
+	o.out().Write(seq)
+}
+
+// rulesMatcher returns the matcher of the rules.
+func (o *Output) rulesMatcher() *Matcher {
+	if o.matcher == nil {
+		o.matcher = NewMatcher(o.Rules)
+	}
+	return o.matcher
+}
+
+// colorize colorizes the line, skipping the part that was already printed.
+// The state is only carried to the next line once the line is complete.
+func (o *Output) colorize(line string, complete bool) string {
+	if o.Plain {
+		return line[min(o.flushed, len(line)):]
+	}
+
+	colored, state := o.rulesMatcher().ColorizeLine(line, o.flushed, o.state)
+	if complete {
+		o.state = state
+	}
+	return colored
+}
+
+// parallel reports whether complete lines are colorized by Parallel workers.
+// Interactive output, plain output and output to a terminal are printed line
+// by line, and stateful rules need the lines in order, so they stay
+// sequential.
+func (o *Output) parallel() bool {
+	if Parallel <= 1 || o.Interactive || o.Plain ||
+		o.rulesMatcher().Stateful() {
+		return false
+	}
+	if o.tty == nil {
+		tty := isTTY(o.Out)
+		o.tty = &tty
+	}
+	return !*o.tty
+}
+
+// out returns the buffered writer of the output file. The pipeline is stopped
+// first, once it printed its lines, so that the output stays in order.
+func (o *Output) out() *bufio.Writer {
+	if o.writer == nil {
+		o.writer = bufio.NewWriterSize(o.Out, 64*1024)
+	}
+	if o.pipeline != nil {
+		o.pipeline.stop()
+		o.pipeline = nil
+	}
+	return o.writer
+}
+
+type (
+	// pipeline colorizes lines with Parallel workers while more are read. A
+	// single goroutine prints them in order, as soon as they and the lines
+	// before them are colorized.
+	pipeline struct {
+		jobs  chan *pendingLine
+		queue chan *pendingLine
+		// printed is closed once the printer has printed every line.
+		printed chan struct{}
+	}
+
+	// pendingLine is a line that ends with end, and its colorized text once
+	// done is closed.
+	pendingLine struct {
+		line    string
+		end     string
+		colored string
+		done    chan struct{}
+	}
+)
+
+// startPipeline starts the workers and the printer of the pipeline.
+func (o *Output) startPipeline() *pipeline {
+	p := &pipeline{
+		jobs:    make(chan *pendingLine, maxPending),
+		queue:   make(chan *pendingLine, maxPending),
+		printed: make(chan struct{}),
+	}
+
+	matcher := o.rulesMatcher()
+	for range Parallel {
+		go func() {
+			defer restoreOnPanic()
+			for job := range p.jobs {
+				job.colored = matcher.Colorize(job.line)
+				close(job.done)
+			}
+		}()
+	}
+
+	w := o.out()
+	go func() {
+		defer restoreOnPanic()
+		defer close(p.printed)
+		for job := range p.queue {
+			<-job.done
+			w.WriteString(o.Prefix)
+			w.WriteString(job.colored)
+			w.WriteString(job.end)
+
+			// Everything read so far is printed.
+			if len(p.queue) == 0 {
+				if err := w.Flush(); err != nil {
+					slog.Debug("Error writing output", "error", err)
+				}
+			}
 		}
-		o.Buffer.Reset()
+	}()
+
+	return p
+}
+
+// add colorizes and prints a line that ends with end, after the lines added
+// before it.
+func (p *pipeline) add(line string, end string) {
+	job := &pendingLine{line: line, end: end, done: make(chan struct{})}
+	p.queue <- job
+	p.jobs <- job
+}
+
+// stop waits until every line is printed, and stops the goroutines.
+func (p *pipeline) stop() {
+	close(p.jobs)
+	close(p.queue)
+	<-p.printed
+}
+
+// writeLine colorizes and prints a line that ends with end, unless the rules
+// hide it. The start of the line that was already printed by an idle flush is
+// skipped, keeping its styles for the rest of the line.
+func (o *Output) writeLine(line string, end string) {
+	defer o.Buffer.Reset()
+
+	// A line is hidden unless its start was already printed. It's still
+	// colorized for the state of the next lines.
+	if o.flushed == 0 && !o.rulesMatcher().Keep(line) {
+		if o.rulesMatcher().Stateful() {
+			o.colorize(line, true)
+		}
+		return
+	}
+
+	if o.flushed == 0 && (o.pipeline != nil || o.parallel()) {
+		if o.pipeline == nil {
+			o.pipeline = o.startPipeline()
+		}
+		o.pipeline.add(line, end)
+		return
+	}
+
+	w := o.out()
+	if o.flushed == 0 {
+		w.WriteString(o.Prefix)
+	}
+	w.WriteString(o.colorize(line, true))
+	w.WriteString(end)
+
+	if o.Interactive {
+		o.flush()
 	}
+
+	o.flushed = 0
 }
 
-func (o *Output) Start(stderr bool) {
-	if Color != "always" && !isTerminal(o.Out) {
-		startRunWithoutColor(o.Command)
-		os.Exit(0)
+// flush writes the buffered output to the output file.
+func (o *Output) flush() {
+	if err := o.out().Flush(); err != nil {
+		slog.Debug("Error writing output", "error", err)
+	}
+}
+
+// idle is called when all output read so far has been written. The buffered
+// output is printed, and a partial line is printed if no more output arrives
+// within IdleFlush, so that prompts are visible.
+func (o *Output) idle() {
+	if o.screen.Raw() {
+		o.FlushRaw()
+	}
+	// The pipeline prints its lines as soon as they are colorized.
+	if o.pipeline == nil {
+		o.flush()
+	}
+
+	if o.screen.Raw() || IdleFlush <= 0 || o.Buffer.Len() <= o.flushed {
+		return
 	}
 
+	o.idleSince = time.Now()
+	if o.timer == nil {
+		o.timer = time.AfterFunc(IdleFlush, o.flushIdle)
+	} else {
+		o.timer.Reset(IdleFlush)
+	}
+}
+
+func (o *Output) flushIdle() {
+	defer restoreOnPanic()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// The timer may have fired while more output was written, after which
+	// it was set again.
+	if o.screen.Raw() || o.Buffer.Len() <= o.flushed ||
+		time.Since(o.idleSince) < IdleFlush {
+		return
+	}
+
+	// A character split across reads waits for the rest of its bytes, so that
+	// no style is printed inside it.
+	line := o.Buffer.String()
+	line = line[:fullRunesLen(line)]
+	if len(line) <= o.flushed {
+		return
+	}
+
+	// A partial line that would be hidden waits until it's complete.
+	if o.flushed == 0 && !o.rulesMatcher().Keep(line) {
+		return
+	}
+
+	w := o.out()
+	if o.flushed == 0 {
+		w.WriteString(o.Prefix)
+	}
+	w.WriteString(o.colorize(line, false))
+	o.flush()
+
+	o.flushed = len(line)
+}
+
+// fullRunesLen returns the length of s without an incomplete UTF-8 character
+// at its end. Invalid bytes count as complete.
+func fullRunesLen(s string) int {
+	for i := len(s) - 1; i >= max(0, len(s)-utf8.UTFMax); i-- {
+		if !utf8.RuneStart(s[i]) {
+			continue
+		}
+		if utf8.FullRuneInString(s[i:]) {
+			return len(s)
+		}
+		return i
+	}
+	return len(s)
+}
+
+func (o *Output) Start(stderr bool) {
 	var ioPipe io.ReadCloser
 	var err error
 	if !stderr {
@@ -91,76 +435,201 @@ func (o *Output) Start(stderr bool) {
 
 	if err := o.Command.Start(); err != nil {
 		slog.Debug("Error starting command", "error", err)
+		Exit(err)
+	}
+	o.Forwarder.Start()
+
+	o.Copy(ioPipe)
+}
+
+// StartStreams runs the command and colorizes stdout and stderr at the same
+// time, each with the rules of its own Output. Every line is written with a
+// single write, so lines of both streams keep the order in which they are read
+// from the pipes.
+func StartStreams(cmd *exec.Cmd, stdout, stderr *Output) {
+	stdoutPipe := stdout.pipe(&cmd.Stdout, cmd.StdoutPipe)
+	stderrPipe := stderr.pipe(&cmd.Stderr, cmd.StderrPipe)
+
+	if err := cmd.Start(); err != nil {
+		slog.Debug("Error starting command", "error", err)
+		Exit(err)
+	}
+	stdout.Forwarder.Start()
+
+	var wg sync.WaitGroup
+	for output, pipe := range map[*Output]io.Reader{
+		stdout: stdoutPipe,
+		stderr: stderrPipe,
+	} {
+		if pipe == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output.Copy(pipe)
+		}()
+	}
+	wg.Wait()
+}
+
+// pipe returns a pipe for the stream of the Output. The stream is connected
+// directly to the output file instead when it can't be colorized.
+func (o *Output) pipe(
+	stream *io.Writer,
+	newPipe func() (io.ReadCloser, error),
+) io.Reader {
+	if len(o.Rules) == 0 || !colorable(o.Out) {
+		*stream = o.Out
+		return nil
+	}
+
+	pipe, err := newPipe()
+	if err != nil {
+		slog.Debug("Error creating pipe", "error", err)
 		os.Exit(1)
 	}
+	return pipe
+}
 
-	reader := bufio.NewReader(ioPipe)
+// Copy colorizes everything read from r until EOF. A trailing line without a
+// newline is flushed once r is exhausted.
+func (o *Output) Copy(r io.Reader) {
+	buf := make([]byte, 32*1024)
 	for {
-		char, _, err := reader.ReadRune()
+		n, err := r.Read(buf)
+		if n > 0 {
+			_, _ = o.Write(buf[:n])
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				slog.Debug("Error reading output", "error", err)
 			}
-			slog.Debug("Error reading from pipe", "error", err)
 			break
 		}
+	}
+
+	o.Flush()
+}
 
-		o.Write(char)
+// Flush colorizes and prints the buffered partial line, if any.
+func (o *Output) Flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+
+	if o.screen.Raw() {
+		o.FlushRaw()
+		o.flush()
+		return
+	}
+
+	if o.Buffer.Len() > o.flushed {
+		o.writeLine(o.Buffer.String(), "")
 	}
+	o.flushed = 0
+	o.Buffer.Reset()
+	o.flush()
 }
 
-func (o *Output) StartWithPTY(stderr bool) {
-	if Color != "always" && (!isTerminal(os.Stderr) || !isTerminal(os.Stdout)) {
-		startRunWithoutColor(o.Command)
-		os.Exit(0)
+// FlushRaw prints the buffered line as it is, without colorizing it.
+func (o *Output) FlushRaw() {
+	if o.Buffer.Len() == 0 {
+		return
 	}
 
+	_, _ = o.Buffer.WriteTo(o.out())
+}
+
+func (o *Output) StartWithPTY(stderr bool) {
 	ptmx, err := pty.Start(o.Command)
 	if err != nil {
 		slog.Debug("Error starting command with pty", "error", err)
-	} else {
-		defer ptmx.Close()
+		Exit(err)
 	}
+	// ptmx is left open until cshift exits. Closing it hangs up the pty,
+	// which kills a command that closed its end but hasn't exited yet.
 
-	go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
-	defer func() { _ = ptmx.Close() }()
-
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGWINCH)
-	go func() {
-		for range ch {
-			if err := pty.InheritSize(os.Stdin, ptmx); err != nil {
-				slog.Debug("Error resizing pty", "error", err)
+	// A background job can't change the terminal settings, and the terminal
+	// is restored before cshift is suspended with the command.
+	if isTTY(os.Stdin) && isForeground() {
+		term, err := MakeRaw(os.Stdin)
+		if err != nil {
+			slog.Debug("Error making terminal raw", "error", err)
+		} else {
+			defer func() { _ = term.Restore() }()
+			defer restoreOnSignal()()
+			if o.Forwarder != nil {
+				o.Forwarder.Suspend = func() { _ = term.Restore() }
+				o.Forwarder.Resume = func() { _ = term.MakeRaw() }
 			}
 		}
-	}()
-	ch <- syscall.SIGWINCH
-	defer func() { signal.Stop(ch); close(ch) }()
+	}
+	o.Forwarder.Start()
 
-	buf := make([]byte, 1024)
-	var partialBuf []byte
+	go forwardInput(ptmx, os.Stdin, o.Forwarder)
+
+	if tty := terminalFile(); tty != nil {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGWINCH)
+		go func() {
+			defer restoreOnPanic()
+			for range ch {
+				if err := pty.InheritSize(tty, ptmx); err != nil {
+					slog.Debug("Error resizing pty", "error", err)
+				}
+			}
+		}()
+		ch <- syscall.SIGWINCH
+		defer func() { signal.Stop(ch); close(ch) }()
+	}
+
+	o.Interactive = true
+	o.Copy(ptmx)
+}
 
+// forwardInput copies stdin to the pty. When stdin isn't a terminal and ends,
+// the EOF character is written to the pty so that the command reads EOF too.
+func forwardInput(ptmx *os.File, stdin *os.File, forwarder *Forwarder) {
+	defer restoreOnPanic()
+
+	interactive := isTTY(stdin)
+	buf := make([]byte, 32*1024)
+	last := byte('\n')
 	for {
-		n, err := ptmx.Read(buf)
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			input := buf[:n]
+			if interactive {
+				input = forwarder.suspendInput(ptmx, input)
+			}
+			if _, err := ptmx.Write(input); err != nil {
+				return
+			}
+			last = buf[n-1]
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				slog.Debug("Error reading stdin", "error", err)
+				return
 			}
-			slog.Debug("Error reading from pty", "error", err)
 			break
 		}
+	}
 
-		partialBuf = append(partialBuf, buf[:n]...)
-
-		for len(partialBuf) > 0 {
-			r, size := utf8.DecodeRune(partialBuf)
-
-			if r == utf8.RuneError && size == 1 {
-				break
-			}
+	eof := []byte{4} // ^D
+	if t, err := unix.IoctlGetTermios(int(ptmx.Fd()), ioctlGetTermios); err == nil {
+		eof[0] = t.Cc[unix.VEOF]
+	}
 
-			o.Write(r)
-			partialBuf = partialBuf[size:]
-		}
+	// In canonical mode, the first EOF character only sends an unterminated
+	// line to the command.
+	if last != '\n' {
+		_, _ = ptmx.Write(eof)
 	}
+	_, _ = ptmx.Write(eof)
 }
@@ -0,0 +1,278 @@
+package cmd_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"cshift/cmd"
+)
+
+// benchmarkInput returns n lines of output for the rules file.
+func benchmarkInput(name string, n int) []byte {
+	var buf bytes.Buffer
+	for i := range n {
+		switch name {
+		case "ping":
+			fmt.Fprintf(
+				&buf,
+				"64 bytes from 10.0.%d.%d: icmp_seq=%d ttl=57 time=%d.%d ms\n",
+				i/256%256, i%256, i, i%100, i%10,
+			)
+		case "go-test":
+			fmt.Fprintf(
+				&buf,
+				"--- PASS: TestSomething%d (0.%02ds)\n    file_test.go:%d: ok\n",
+				i,
+				i%100,
+				i,
+			)
+		}
+	}
+	return buf.Bytes()
+}
+
+// colorizeFile colorizes the input with an Output and returns what it printed.
+func colorizeFile(t *testing.T, rules []cmd.Rule, input []byte) []byte {
+	f, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	output := cmd.Output{Rules: rules, Out: f, Prefix: "> "}
+	output.Copy(bytes.NewReader(input))
+
+	content, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestOutputParallel(t *testing.T) {
+	cmdRules, err := cmd.LoadRulesFile("../rules/ping.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cmdRules.AllRules()
+	input := append(benchmarkInput("ping", 5000), "partial"...)
+
+	expected := colorizeFile(t, rules, input)
+
+	cmd.Parallel = 4
+	defer func() { cmd.Parallel = 0 }()
+
+	if colored := colorizeFile(t, rules, input); !bytes.Equal(
+		colored,
+		expected,
+	) {
+		t.Fatalf("expected the same output in parallel, but got %q", colored)
+	}
+}
+
+func TestOutputParallelStreaming(t *testing.T) {
+	cmd.Parallel = 4
+	defer func() { cmd.Parallel = 0 }()
+
+	f, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, w := io.Pipe()
+	output := cmd.Output{Out: f}
+	done := make(chan struct{})
+	go func() {
+		output.Copy(r)
+		close(done)
+	}()
+
+	// The lines are printed while the input is still open.
+	for _, line := range []string{"one", "two"} {
+		fmt.Fprintln(w, line)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			content, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(content, []byte(line+"\x1b[0m\n")) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %q to be printed, but got %q", line, content)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	w.Close()
+	<-done
+	content, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "one\x1b[0m\ntwo\x1b[0m\n"; string(content) != expected {
+		t.Fatalf("expected %q, but got %q", expected, content)
+	}
+}
+
+func TestOutputFilter(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '^debug'
+action = 'hide'
+
+[[rules]]
+regexp = '^warn'
+action = 'dim'
+colors = 'yellow'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "info: started\ndebug: details\nwarn: slow\nerror: failed"
+	expected := "> info: started\x1b[0m\n" +
+		"> \x1b[2;33mwarn\x1b[0;2m: slow\x1b[0m\n" +
+		"> error: failed\x1b[0m"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+
+	err = cmdRules.AddFilters([]string{"^(info|debug|error)"}, []string{"fail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "> info: started\x1b[0m\n"
+	colored = string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+}
+
+func TestOutputIdleFlushUTF8(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules("[[rules]]\nregexp = '€'\ncolors = 'red'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idleFlush := cmd.IdleFlush
+	cmd.IdleFlush = time.Millisecond
+	defer func() { cmd.IdleFlush = idleFlush }()
+
+	f, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	output := cmd.Output{Rules: cmdRules.Rules, Out: f}
+	_, _ = output.Write([]byte("price: 5\xe2\x82"))
+	time.Sleep(50 * time.Millisecond)
+
+	content, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "price: 5\x1b[0m"; string(content) != expected {
+		t.Fatalf(
+			"expected %q before the rest of €, but got %q",
+			expected,
+			content,
+		)
+	}
+
+	_, _ = output.Write([]byte("\xac\n"))
+	output.Flush()
+
+	content, err = os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "price: 5\x1b[0m\x1b[31m€\x1b[0m\x1b[0m\n"; string(
+		content,
+	) != expected {
+		t.Fatalf("expected %q, but got %q", expected, content)
+	}
+}
+
+func BenchmarkOutput(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	for _, name := range []string{"ping", "go-test"} {
+		cmdRules, err := cmd.LoadRulesFile("../rules/" + name + ".toml")
+		if err != nil {
+			b.Fatal(err)
+		}
+		input := benchmarkInput(name, 10000)
+
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				output := cmd.Output{Rules: cmdRules.AllRules(), Out: devNull}
+				output.Copy(bytes.NewReader(input))
+			}
+		})
+
+		b.Run(name+"/parallel", func(b *testing.B) {
+			cmd.Parallel = runtime.NumCPU()
+			defer func() { cmd.Parallel = 0 }()
+
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				output := cmd.Output{Rules: cmdRules.AllRules(), Out: devNull}
+				output.Copy(bytes.NewReader(input))
+			}
+		})
+
+		b.Run(name+"/none", func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				output := cmd.Output{Out: devNull}
+				output.Copy(bytes.NewReader(input))
+			}
+		})
+
+		b.Run(name+"/cat", func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for b.Loop() {
+				_, _ = io.Copy(devNull, bytes.NewReader(input))
+			}
+		})
+	}
+}
+
+func TestOutputRedraw(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '\d+%'
+colors = 'green'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A progress bar moves the cursor up to redraw its line, which is still
+	// colorized.
+	input := "a 10%\n\x1b[1A\x1b[2Ka 20%\nb \x1b[1Ab 30%\n"
+	expected := "> a \x1b[32m10%\x1b[0m\x1b[0m\n" +
+		"\x1b[1A> \x1b[2Ka \x1b[32m20%\x1b[0m\x1b[0m\n" +
+		"> b \x1b[0m\x1b[1A> b \x1b[32m30%\x1b[0m\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Errorf("expected %q, but got %q", expected, colored)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/adrg/xdg"
 	"github.com/gobwas/glob"
@@ -22,48 +23,63 @@ type LsColor struct {
 	Code string
 }
 
-var LsColorsMap []LsColor
+var (
+	LsColorsMap []LsColor
+	// lsColorsMu guards the first fill of LsColorsMap, since lines are
+	// colorized by several workers at once with --parallel.
+	lsColorsMu sync.Mutex
+)
 
 func GetLsColor(line string) (string, error) {
+	for _, lsColor := range loadLsColors() {
+		fileName := filepath.Base(line)
+		if lsColor.Glob.Match(fileName) {
+			return lsColor.Code, nil
+		}
+	}
+
+	return "", fmt.Errorf("File color doesn't exists")
+}
+
+// loadLsColors fills LsColorsMap from LS_COLORS, or the built-in colors, if
+// it's empty, and returns it.
+func loadLsColors() []LsColor {
+	lsColorsMu.Lock()
+	defer lsColorsMu.Unlock()
+
+	if len(LsColorsMap) > 0 {
+		return LsColorsMap
+	}
+
 	lsColors := os.Getenv("LS_COLORS")
 
 	if len(lsColors) <= 0 {
 		lsColors = DefaultLsColors
 	}
 
-	if len(LsColorsMap) == 0 {
-		entries := strings.SplitSeq(lsColors, ":")
-		for entry := range entries {
-			parts := strings.SplitN(entry, "=", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			pattern := parts[0]
-			colorCode := parts[1]
-
-			g, err := glob.Compile(pattern)
-			if err != nil {
-				slog.Debug(
-					"Failed compiling glob",
-					"pattern",
-					pattern,
-					"error",
-					err,
-				)
-				continue
-			}
-			LsColorsMap = append(LsColorsMap, LsColor{Glob: g, Code: colorCode})
+	entries := strings.SplitSeq(lsColors, ":")
+	for entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
 		}
-	}
-
-	for _, lsColor := range LsColorsMap {
-		fileName := filepath.Base(line)
-		if lsColor.Glob.Match(fileName) {
-			return lsColor.Code, nil
+		pattern := parts[0]
+		colorCode := parts[1]
+
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			slog.Debug(
+				"Failed compiling glob",
+				"pattern",
+				pattern,
+				"error",
+				err,
+			)
+			continue
 		}
+		LsColorsMap = append(LsColorsMap, LsColor{Glob: g, Code: colorCode})
 	}
-
-	return "", fmt.Errorf("File color doesn't exists")
+	return LsColorsMap
 }
 
 type PathKind int
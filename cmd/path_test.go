@@ -2,6 +2,7 @@ package cmd_test
 
 import (
 	"os"
+	"sync"
 	"testing"
 
 	"cshift/cmd"
@@ -55,3 +56,36 @@ func TestGetLsColor(t *testing.T) {
 		}
 	})
 }
+
+// TestColorizePathParallel is meant to run with -race: the workers of
+// --parallel load LS_COLORS for path styles at the same time.
+func TestColorizePathParallel(t *testing.T) {
+	cmdRules, err := cmd.LoadRulesFile("../rules/find.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := cmd.NewMatcher(cmdRules.AllRules())
+	expected := "\x1b[34m./src/\x1b[36mmain.go\x1b[0m\x1b[0m"
+
+	t.Setenv("LS_COLORS", "")
+	lsColorsMap := cmd.LsColorsMap
+	cmd.LsColorsMap = nil
+	defer func() { cmd.LsColorsMap = lsColorsMap }()
+
+	colored := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range colored {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			colored[i] = matcher.Colorize("./src/main.go")
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range colored {
+		if c != expected {
+			t.Fatalf("expected %q, but got %q", expected, c)
+		}
+	}
+}
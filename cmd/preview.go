@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/muesli/termenv"
+	"github.com/spf13/cobra"
+)
+
+var (
+	PreviewRules    string
+	PreviewAnnotate bool
+)
+
+func init() {
+	previewCmd.Flags().
+		StringVar(&PreviewRules, "rules", "", "path to the rules file to apply")
+	previewCmd.Flags().
+		BoolVarP(&PreviewAnnotate, "annotate", "a", false, "show which rule and group styled each span")
+	previewCmd.MarkFlagRequired("rules")
+	rootCmd.AddCommand(previewCmd)
+}
+
+var previewCmd = &cobra.Command{
+	Use:   "preview --rules <file> [input-file|-]",
+	Short: "Apply a rules file to captured output",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmdRules, err := LoadRulesFile(PreviewRules)
+		if err != nil {
+			return fmt.Errorf("failed to load rules: %w", err)
+		}
+
+		input := io.Reader(os.Stdin)
+		if len(args) == 1 && args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			input = file
+		}
+
+		rules := cmdRules.AllRules()
+		if !PreviewAnnotate {
+			output := Output{Rules: rules, Out: os.Stdout}
+			output.Copy(input)
+			return nil
+		}
+
+		return annotate(input, rules)
+	},
+}
+
+// annotate prints every colorized line of input followed by the spans that
+// were styled in it.
+func annotate(input io.Reader, rules []Rule) error {
+	dim := termenv.String().Faint()
+	matcher := NewMatcher(rules)
+	var state LineState
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRightFunc(scanner.Text(), unicode.IsSpace)
+
+		var colored string
+		var spans []Span
+		colored, spans, state = matcher.Annotate(line, state)
+		fmt.Println(colored)
+
+		for _, span := range spans {
+			info := fmt.Sprintf(
+				"  %s [%d:%d] %q",
+				describe(span),
+				span.Start,
+				span.End,
+				span.Text,
+			)
+			if len(span.Codes) == 0 {
+				fmt.Println(dim.Styled(info))
+				continue
+			}
+			fmt.Println(dim.Styled(info), styleSample(span.Codes))
+		}
+	}
+
+	return scanner.Err()
+}
+
+// describe tells what styled the span, like "rule #2 group 1 (time)".
+func describe(span Span) string {
+	var parts []string
+	if span.Rule > 0 {
+		parts = append(parts, fmt.Sprintf("rule #%d", span.Rule))
+	}
+
+	switch span.Kind {
+	case "", "path", "value":
+		group := fmt.Sprintf("group %d", span.Group)
+		if span.Name != "" {
+			group += " (" + span.Name + ")"
+		}
+		parts = append(parts, group)
+		if span.Kind != "" {
+			parts = append(parts, span.Kind)
+		}
+	default:
+		parts = append(parts, span.Kind)
+	}
+	return strings.Join(parts, " ")
+}
+
+// styleSample returns the SGR parameters of a style, in that style.
+func styleSample(codes []string) string {
+	params := strings.Join(codes, ";")
+	return "\x1b[" + params + "m" + params + "\x1b[" + termenv.ResetSeq + "m"
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+)
+
+// MatchTimeout limits the time the pcre engine spends matching a line, since
+// backtracking can take exponential time.
+const MatchTimeout = 100 * time.Millisecond
+
+// engineFlags are the flags each engine supports.
+var engineFlags = map[string]string{
+	"re2":  "imsU",
+	"pcre": "imsx",
+}
+
+// Regexp is the regular expression of a rule. It's compiled by DecodeRules
+// with the engine and flags of the rule: Go's RE2 regexp by default, or a
+// backtracking engine that supports lookarounds and backreferences.
+type Regexp struct {
+	expr string
+	re2  *regexp.Regexp
+	pcre *regexp2.Regexp
+}
+
+// CompileRegexp compiles expr with the engine ("re2" or "pcre", "" is "re2")
+// and the flags, e.g. "im".
+func CompileRegexp(expr string, engine string, flags string) (*Regexp, error) {
+	re := Regexp{expr: expr}
+	if err := re.Compile(engine, flags); err != nil {
+		return nil, err
+	}
+	return &re, nil
+}
+
+// UnmarshalText stores the expression. It's compiled later by Compile, when
+// the engine and flags of the rule are known.
+func (r *Regexp) UnmarshalText(text []byte) error {
+	r.expr = string(text)
+	return nil
+}
+
+// Compile compiles the expression with the engine and flags.
+func (r *Regexp) Compile(engine string, flags string) error {
+	if engine == "" {
+		engine = "re2"
+	}
+
+	supported, ok := engineFlags[engine]
+	if !ok {
+		return fmt.Errorf("unknown regexp engine %q", engine)
+	}
+
+	for _, flag := range flags {
+		if !strings.ContainsRune(supported, flag) {
+			return fmt.Errorf("unknown %s regexp flag %q", engine, flag)
+		}
+	}
+
+	expr := r.expr
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+
+	var err error
+	r.re2, r.pcre = nil, nil
+	if engine == "pcre" {
+		r.pcre, err = regexp2.Compile(expr, regexp2.None)
+		if r.pcre != nil {
+			r.pcre.MatchTimeout = MatchTimeout
+		}
+	} else {
+		r.re2, err = regexp.Compile(expr)
+	}
+	return err
+}
+
+// String returns the expression of the regexp.
+func (r *Regexp) String() string {
+	return r.expr
+}
+
+// RE2 returns the compiled RE2 regexp, or nil if the regexp uses another
+// engine.
+func (r *Regexp) RE2() *regexp.Regexp {
+	return r.re2
+}
+
+// SubexpNames returns the names of the groups by number, like
+// regexp.Regexp.SubexpNames. Unnamed groups have an empty name.
+func (r *Regexp) SubexpNames() []string {
+	if r.re2 != nil {
+		return r.re2.SubexpNames()
+	}
+	if r.pcre == nil {
+		return nil
+	}
+
+	var names []string
+	for _, number := range r.pcre.GetGroupNumbers() {
+		name := r.pcre.GroupNameFromNumber(number)
+		if name == strconv.Itoa(number) {
+			name = ""
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// SubexpIndex returns the number of the group with the name, like
+// regexp.Regexp.SubexpIndex, or -1 if there is none. The pcre engine numbers
+// named groups after the unnamed ones.
+func (r *Regexp) SubexpIndex(name string) int {
+	if r.re2 != nil {
+		return r.re2.SubexpIndex(name)
+	}
+	if r.pcre == nil || name == "" {
+		return -1
+	}
+
+	// Unnamed groups are named by their number.
+	number := r.pcre.GroupNumberFromName(name)
+	if number < 0 || name == strconv.Itoa(number) {
+		return -1
+	}
+	return slices.Index(r.pcre.GetGroupNumbers(), number)
+}
+
+// MatchString reports whether s contains a match.
+func (r *Regexp) MatchString(s string) bool {
+	if r.re2 != nil {
+		return r.re2.MatchString(s)
+	}
+	if r.pcre == nil {
+		return false
+	}
+
+	matched, err := r.pcre.MatchString(s)
+	if err != nil {
+		slog.Debug("Error matching regexp", "regexp", r.expr, "error", err)
+	}
+	return matched
+}
+
+// FindAllStringSubmatchIndex returns the byte offsets of the matches and their
+// groups in s, like regexp.Regexp.FindAllStringSubmatchIndex. Unmatched groups
+// are -1.
+func (r *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	if r.re2 != nil {
+		return r.re2.FindAllStringSubmatchIndex(s, n)
+	}
+	if r.pcre == nil {
+		return nil
+	}
+
+	var matches [][]int
+	var offsets []int
+
+	m, err := r.pcre.FindStringMatch(s)
+	for ; m != nil && err == nil; m, err = r.pcre.FindNextMatch(m) {
+		if n >= 0 && len(matches) == n {
+			break
+		}
+
+		// The engine returns offsets in runes.
+		if offsets == nil {
+			offsets = runeOffsets(s)
+		}
+
+		groups := m.Groups()
+		match := make([]int, 0, 2*len(groups))
+		for _, group := range groups {
+			if len(group.Captures) == 0 {
+				match = append(match, -1, -1)
+				continue
+			}
+			match = append(
+				match,
+				offsets[group.Index],
+				offsets[group.Index+group.Length],
+			)
+		}
+		matches = append(matches, match)
+	}
+
+	if err != nil {
+		slog.Debug("Error matching regexp", "regexp", r.expr, "error", err)
+	}
+	return matches
+}
+
+// runeOffsets returns the byte offset of every rune of s, and of its end.
+// Every byte that isn't valid UTF-8 is a rune, like in []rune(s).
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i := 0; i < len(s); {
+		offsets = append(offsets, i)
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return append(offsets, len(s))
+}
@@ -0,0 +1,49 @@
+package cmd_test
+
+import (
+	"slices"
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestRegexpPCRE(t *testing.T) {
+	re, err := cmd.CompileRegexp(`(?<=é )(\w+)(?= \1)`, "pcre", "i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches := re.FindAllStringSubmatchIndex("é Hello hello é x x", -1)
+	expected := [][]int{{3, 8, 3, 8}, {18, 19, 18, 19}}
+	if !slices.EqualFunc(matches, expected, slices.Equal) {
+		t.Fatalf("expected %v, but got %v", expected, matches)
+	}
+}
+
+func TestDecodeRulesFlags(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '^error'
+flags = 'im'
+colors = 'red'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re := cmdRules.Rules[0].Regexp
+	matches := re.FindAllStringSubmatchIndex("ok\nERROR", -1)
+	if len(matches) != 1 {
+		t.Fatalf("expected a case-insensitive match, but got %v", matches)
+	}
+
+	for _, content := range []string{
+		"[[rules]]\nregexp = 'a'\nengine = 'perl'",
+		"[[rules]]\nregexp = 'a'\nflags = 'x'",
+		"[[rules]]\nregexp = '(?<=a)b'",
+	} {
+		if _, err := cmd.DecodeRules(content); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
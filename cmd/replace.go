@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/muesli/termenv"
+)
+
+// replacePhases are the valid phases of a replace rule.
+var replacePhases = []string{"", "before", "after"}
+
+type (
+	// edit replaces the part of a line from start to end with text.
+	edit struct {
+		start int
+		end   int
+		text  string
+	}
+
+	// replacement is the text of a replacement in a line, its style and the
+	// rule that replaced it.
+	replacement struct {
+		start int
+		end   int
+		codes []string
+		rule  *Rule
+	}
+)
+
+// prepareReplace checks the replace fields of a rule.
+func (r *Rule) prepareReplace() error {
+	if !slices.Contains(replacePhases, r.Phase) {
+		return fmt.Errorf("unknown phase %q", r.Phase)
+	}
+	if r.Replace == nil {
+		if r.Phase != "" {
+			return fmt.Errorf("phase needs replace")
+		}
+		return nil
+	}
+
+	switch {
+	case r.Regexp == nil:
+		return fmt.Errorf("replace needs a regexp")
+	case r.Count != "" && r.Count != "once" && r.Count != "more":
+		return fmt.Errorf("replace rules can't have count %q", r.Count)
+	}
+	return nil
+}
+
+// replacePhase reports whether the rule replaces text in the phase.
+func (r *Rule) replacePhase(phase string) bool {
+	if r.Replace == nil {
+		return false
+	}
+	if r.Phase == "" {
+		return phase == "before"
+	}
+	return r.Phase == phase
+}
+
+// Expand expands the template with the groups of a match in s, like
+// regexp.Regexp.Expand: $1 or ${1} is a group by number, $name or ${name} by
+// name, and $$ is a $.
+func (r *Regexp) Expand(template string, s string, match []int) string {
+	var buf strings.Builder
+
+	for {
+		before, after, found := strings.Cut(template, "$")
+		buf.WriteString(before)
+		if !found {
+			break
+		}
+
+		if strings.HasPrefix(after, "$") {
+			buf.WriteByte('$')
+			template = after[1:]
+			continue
+		}
+
+		name, rest, ok := expandName(after)
+		if !ok {
+			buf.WriteByte('$')
+			template = after
+			continue
+		}
+		template = rest
+
+		group, err := strconv.Atoi(name)
+		if err != nil {
+			group = r.SubexpIndex(name)
+		}
+		if group >= 0 && 2*group+1 < len(match) && match[2*group] >= 0 {
+			buf.WriteString(s[match[2*group]:match[2*group+1]])
+		}
+	}
+
+	return buf.String()
+}
+
+// expandName returns the name after a $ in a template, and the rest of it.
+func expandName(template string) (string, string, bool) {
+	if after, ok := strings.CutPrefix(template, "{"); ok {
+		name, rest, found := strings.Cut(after, "}")
+		return name, rest, found && name != ""
+	}
+
+	end := 0
+	for end < len(template) {
+		c := template[end]
+		if c != '_' && (c < '0' || c > '9') && (c < 'a' || c > 'z') &&
+			(c < 'A' || c > 'Z') {
+			break
+		}
+		end++
+	}
+	return template[:end], template[end:], end > 0
+}
+
+// replace replaces the matches of the rules of the phase in the line. The
+// styles of the index, the offset from and the earlier replacements move
+// along with the text. The replacements of the "after" phase are styled in
+// the index, and the ones before are returned, to be styled over the other
+// rules. The spans of notes move along with the text too.
+func replace(
+	line string,
+	rules []Rule,
+	phase string,
+	index Index,
+	from *int,
+	replaced []replacement,
+	notes *annotation,
+) (string, []replacement) {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.replacePhase(phase) {
+			continue
+		}
+
+		matches := rule.Regexp.FindAllStringSubmatchIndex(line, rule.Limit())
+		if len(matches) == 0 {
+			continue
+		}
+
+		var edits []edit
+		for _, match := range matches {
+			edits = append(edits, edit{
+				start: match[0],
+				end:   match[1],
+				text:  rule.Regexp.Expand(*rule.Replace, line, match),
+			})
+		}
+
+		var buf strings.Builder
+		last := 0
+		for _, e := range edits {
+			buf.WriteString(line[last:e.start])
+			buf.WriteString(e.text)
+			last = e.end
+		}
+		buf.WriteString(line[last:])
+
+		index.move(edits)
+		notes.move(edits)
+		*from = movePos(*from, edits)
+		for j := range replaced {
+			replaced[j].start = movePos(replaced[j].start, edits)
+			replaced[j].end = movePos(replaced[j].end, edits)
+		}
+
+		codes := blockStyle(rule.Styles())
+		shift := 0
+		for _, e := range edits {
+			start := e.start + shift
+			shift += len(e.text) - (e.end - e.start)
+			if len(codes) == 0 || e.text == "" {
+				continue
+			}
+
+			end := start + len(e.text)
+			if phase == "before" {
+				replaced = append(
+					replaced,
+					replacement{start, end, codes, rule},
+				)
+				continue
+			}
+			index.Overlay(start, end, codes)
+			notes.add(rule, "replace", 0, start, end, codes)
+		}
+
+		line = buf.String()
+	}
+	return line, replaced
+}
+
+// movePos returns the offset in the line after the edits of an offset before
+// them. Offsets in replaced text move to the end of the replacement.
+func movePos(pos int, edits []edit) int {
+	shift := 0
+	for _, e := range edits {
+		if pos <= e.start {
+			break
+		}
+		if pos < e.end {
+			return e.start + shift + len(e.text)
+		}
+		shift += len(e.text) - (e.end - e.start)
+	}
+	return pos + shift
+}
+
+// move moves the styles of the index along with the text of the edits. The
+// styles that start or end in replaced text are applied after the
+// replacement, so that the text after it keeps its style.
+func (i Index) move(edits []edit) {
+	positions := slices.Sorted(maps.Keys(i))
+	moved := make(Index, len(i))
+
+	for _, e := range edits {
+		inside := false
+		for _, pos := range positions {
+			if pos > e.start && pos <= e.end {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			continue
+		}
+
+		end := movePos(e.end, edits)
+		moved[end] = append([]string{termenv.ResetSeq}, i.active(e.end+1)...)
+	}
+
+	for _, pos := range positions {
+		if e, ok := editAt(pos, edits); ok && pos > e.start && pos <= e.end {
+			continue
+		}
+		newPos := movePos(pos, edits)
+		moved[newPos] = append(moved[newPos], i[pos]...)
+	}
+
+	clear(i)
+	maps.Copy(i, moved)
+}
+
+// editAt returns the edit whose replaced text contains pos or ends at it.
+func editAt(pos int, edits []edit) (edit, bool) {
+	for _, e := range edits {
+		if pos >= e.start && pos <= e.end {
+			return e, true
+		}
+	}
+	return edit{}, false
+}
@@ -0,0 +1,78 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestExpand(t *testing.T) {
+	re, err := cmd.CompileRegexp(`(?P<key>\w+)=(\w+)`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := "a=1 user=root"
+	match := re.FindAllStringSubmatchIndex(s, -1)[1]
+	for template, expected := range map[string]string{
+		"$key: $2":     "user: root",
+		"${1}s=${2}!":  "users=root!",
+		"$$2 $missing": "$2 ",
+		"$ and ${":     "$ and ${",
+	} {
+		if expanded := re.Expand(template, s, match); expanded != expected {
+			t.Errorf(
+				"expected %q for %q, but got %q",
+				expected,
+				template,
+				expanded,
+			)
+		}
+	}
+}
+
+func TestColorizeReplace(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = '\b(E[A-Z]+)\b'
+colors = ',red'
+
+[[rules]]
+regexp = '= -1 (ENOENT)$'
+replace = '= -1 $1 (No such file or directory)'
+colors = 'hiblack'
+
+[[rules]]
+regexp = '^(\w+)\('
+colors = ',bold'
+
+[[rules]]
+regexp = '^open'
+replace = 'openat'
+phase = 'after'
+colors = 'yellow'
+
+[[rules]]
+regexp = ', 0x[0-9a-f]+'
+replace = ''
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "open(\"/x\", 0x80000) = -1 ENOENT\n"
+	expected := "> \x1b[1;33mopenat\x1b[0m(\"/x\") " +
+		"\x1b[90m= -1 \x1b[31mENOENT\x1b[0;90m (No such file or directory)" +
+		"\x1b[0m\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+	// The offset of a partial line moves with the replacements before it.
+	expected = "\x1b[1;33m\x1b[0m(\"/x\") \x1b[90m= -1 \x1b[31mENOENT\x1b[0;90m " +
+		"(No such file or directory)\x1b[0m\x1b[0m"
+	colored = cmd.ColorizeFrom(input[:len(input)-1], cmdRules.Rules, 4)
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+}
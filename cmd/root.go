@@ -1,11 +1,12 @@
 package cmd
 
 import (
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
-	"os/signal"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/MatusOllah/slogcolor"
 	"github.com/carapace-sh/carapace"
@@ -21,19 +22,38 @@ var (
 	Color          string
 	ConfigFile     string
 	RulesDirectory string
+	RulesFile      string
+	RulesAs        string
 	Debug          bool
 	UseColor       bool
+	IdleFlush      time.Duration
+	Parallel       int
+	Grep           []string
+	Hide           []string
 )
 
 func init() {
 	rootCmd.SetErrPrefix("ChromaShift Error:")
-	rootCmd.Flags().
+	rootCmd.PersistentFlags().
 		StringVar(&ConfigFile, "config", "", "specify path to the config file")
-	rootCmd.Flags().
+	rootCmd.PersistentFlags().
 		StringVar(&RulesDirectory, "rules-dir", "", "specify path to the rules directory")
 	rootCmd.Flags().
+		StringVar(&RulesFile, "rules", "", "specify path to the rules file to use")
+	rootCmd.Flags().
+		StringVar(&RulesAs, "as", "", "use the rules of the given command")
+	rootCmd.PersistentFlags().
 		StringVar(&Color, "color", "auto", "whether use color or not (never, auto, always)")
-	rootCmd.Flags().BoolVarP(&Debug, "debug", "d", false, "verbose output")
+	rootCmd.Flags().
+		DurationVar(&IdleFlush, "idle-flush", 100*time.Millisecond, "print a partial line after no output for this long (0 to disable)")
+	rootCmd.Flags().
+		IntVar(&Parallel, "parallel", 0, "colorize large outputs with this many workers (0 to disable)")
+	rootCmd.Flags().
+		StringArrayVar(&Grep, "grep", nil, "only print lines matching this regexp")
+	rootCmd.Flags().
+		StringArrayVar(&Hide, "hide", nil, "hide lines matching this regexp")
+	rootCmd.PersistentFlags().
+		BoolVarP(&Debug, "debug", "d", false, "verbose output")
 	carapace.Gen(rootCmd)
 }
 
@@ -41,18 +61,59 @@ func isTerminal(f *os.File) bool {
 	return termenv.NewOutput(f).EnvColorProfile() == termenv.TrueColor
 }
 
-func startRunWithoutColor(runCmd *exec.Cmd) {
-	runCmd.Stderr = os.Stderr
+// startRunWithoutColor runs the command with its output left as it is. Only
+// the lines dropped by --grep and --hide, if any, are left out.
+func startRunWithoutColor(runCmd *exec.Cmd, cmdRules *CommandRules) {
+	runCmd.Stdin = os.Stdin
 	runCmd.Stdout = os.Stdout
-	runCmd.Run()
-	os.Exit(0)
+	runCmd.Stderr = os.Stderr
+
+	var copies sync.WaitGroup
+	stdout, stderr := cmdRules.lineFilters()
+	for _, stream := range []struct {
+		out   *os.File
+		rules []Rule
+		pipe  func() (io.ReadCloser, error)
+	}{
+		{os.Stdout, stdout, runCmd.StdoutPipe},
+		{os.Stderr, stderr, runCmd.StderrPipe},
+	} {
+		if !NewMatcher(stream.rules).Filters() {
+			continue
+		}
+
+		if stream.out == os.Stdout {
+			runCmd.Stdout = nil
+		} else {
+			runCmd.Stderr = nil
+		}
+		pipe, err := stream.pipe()
+		if err != nil {
+			Exit(err)
+		}
+
+		copies.Add(1)
+		go func() {
+			defer copies.Done()
+			copyFiltered(stream.out, pipe, stream.rules)
+		}()
+	}
+
+	forwarder := NewForwarder(runCmd, false)
+	if err := runCmd.Start(); err != nil {
+		Exit(err)
+	}
+	forwarder.Start()
+
+	copies.Wait()
+	Exit(runCmd.Wait())
 }
 
 var rootCmd = &cobra.Command{
 	Use:     "cshift",
 	Version: Version,
 	Short:   "A output colorizer for your favorite commands",
-	PreRun: func(cmd *cobra.Command, args []string) {
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		switch Color {
 		case "never":
 			UseColor = false
@@ -62,6 +123,14 @@ var rootCmd = &cobra.Command{
 			UseColor = isTerminal(os.Stdout)
 		}
 
+		// Without a terminal, like with --color always, the profile comes
+		// from the environment, and plain ANSI colors are the least.
+		ColorProfile = termenv.NewOutput(os.Stdout, termenv.WithUnsafe()).
+			EnvColorProfile()
+		if ColorProfile == termenv.Ascii {
+			ColorProfile = termenv.ANSI
+		}
+
 		opts := slogcolor.DefaultOptions
 		if Debug {
 			opts.Level = slog.LevelDebug
@@ -87,6 +156,14 @@ var rootCmd = &cobra.Command{
 		slog.SetDefault(slog.New(slogcolor.NewHandler(os.Stderr, opts)))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 && (RulesFile != "" || RulesAs != "") {
+			if err := startFilter(); err != nil {
+				cmd.PrintErrln(cmd.ErrPrefix(), err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+
 		UseColor = true
 
 		if len(args) < 1 {
@@ -99,59 +176,79 @@ var rootCmd = &cobra.Command{
 
 		runCmd := exec.Command(cmdName, cmdArgs...)
 
-		if !UseColor {
-			startRunWithoutColor(runCmd)
-		}
-
 		config, err := LoadConfig()
 		if err != nil {
 			slog.Debug("Failed to load config", "error", err)
 		}
 
-		ruleFileName, err := GetRuleFileName(config, args)
-		if err != nil {
-			slog.Debug("No config exists for current command")
-			startRunWithoutColor(runCmd)
-		} else {
-			slog.Debug("Rules file name", "name", ruleFileName)
-		}
-
-		cmdRules, err := LoadRules(ruleFileName)
+		cmdRules, err := SelectRules(config, args)
 		if err != nil {
 			slog.Debug("Failed to load rules for current command", "error", err)
-			startRunWithoutColor(runCmd)
+			startRunWithoutColor(runCmd, nil)
 		}
 
-		if cmdRules.Rules == nil {
+		if cmdRules.Empty() {
 			slog.Debug("No config exists for current command")
-			startRunWithoutColor(runCmd)
+			startRunWithoutColor(runCmd, cmdRules)
 		}
 
-		slog.Debug("Rules found", "count", len(cmdRules.Rules))
+		if !UseColor {
+			startRunWithoutColor(runCmd, cmdRules)
+		}
 
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		slog.Debug(
+			"Rules found",
+			"count",
+			len(cmdRules.Rules),
+			"stdout",
+			len(cmdRules.StdoutRules),
+			"stderr",
+			len(cmdRules.StderrRules),
+		)
 
-		go func() {
-			sig := <-sigChan
-			if err := runCmd.Process.Signal(sig); err != nil {
-				slog.Debug("Error sending signal to process", "error", err)
+		if cmdRules.PTY {
+			if !colorable(os.Stdout) || !colorable(os.Stderr) {
+				startRunWithoutColor(runCmd, cmdRules)
 			}
-		}()
+		} else if !cmdRules.HasStreams() {
+			if !colorable(outputFile(cmdRules.Stderr)) {
+				startRunWithoutColor(runCmd, cmdRules)
+			}
+		}
+
+		forwarder := NewForwarder(runCmd, cmdRules.PTY)
 
 		if cmdRules.PTY {
-			outputReader := NewOutput(runCmd, cmdRules.Rules, cmdRules.Stderr)
+			// stdout and stderr are the same terminal, so the rules of both
+			// streams apply to everything.
+			outputReader := NewOutput(
+				runCmd,
+				cmdRules.AllRules(),
+				cmdRules.Stderr,
+			)
+			outputReader.Forwarder = forwarder
 			outputReader.StartWithPTY(cmdRules.Stderr)
+		} else if cmdRules.HasStreams() {
+			runCmd.Stdin = os.Stdin
+			stdout := NewOutput(runCmd, cmdRules.StreamRules(false), false)
+			stdout.Forwarder = forwarder
+			StartStreams(
+				runCmd,
+				stdout,
+				NewOutput(runCmd, cmdRules.StreamRules(true), true),
+			)
 		} else {
 			runCmd.Stdin = os.Stdin
 			outputReader := NewOutput(runCmd, cmdRules.Rules, cmdRules.Stderr)
+			outputReader.Forwarder = forwarder
 			outputReader.Start(cmdRules.Stderr)
 		}
 
-		if err := runCmd.Wait(); err != nil {
+		err = runCmd.Wait()
+		if err != nil {
 			slog.Debug("Error waiting for command", "error", err)
-			os.Exit(1)
 		}
+		Exit(err)
 	},
 }
 
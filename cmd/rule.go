@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -17,21 +19,421 @@ var StaticRulesDirectory embed.FS
 
 type (
 	CommandRules struct {
-		Rules  []Rule `toml:"rules"`
-		Stderr bool   `toml:"stderr"`
-		PTY    bool   `toml:"pty"`
+		Rules  []Rule
+		Stderr bool
+		PTY    bool
+
+		// StdoutRules and StderrRules are the rules of the [stdout] and
+		// [stderr] tables. Both streams are colorized at the same time when a
+		// rules file has them, and Rules apply to both.
+		StdoutRules []Rule
+		StderrRules []Rule
+	}
+
+	// RuleSet is a [stdout] or [stderr] table of a rules file.
+	RuleSet struct {
+		Rules []Rule `toml:"rules"`
+	}
+
+	// ruleFile is the layout of a rules file. The stderr key is either the
+	// legacy boolean or a [stderr] table, so it is decoded by its type.
+	ruleFile struct {
+		Rules  []Rule            `toml:"rules"`
+		PTY    bool              `toml:"pty"`
+		Stdout RuleSet           `toml:"stdout"`
+		Stderr toml.Primitive    `toml:"stderr"`
+		States map[string]*State `toml:"states"`
+		Table  *Table            `toml:"table"`
+	}
+
+	// State is a named state of a rules file, for output whose meaning
+	// changes by section. A line matching Enter switches to the state, and
+	// the state is left after a line matching Leave. Only the rules of the
+	// current state apply, or the file's rules outside of any state.
+	State struct {
+		Name  string  `toml:"-"`
+		Enter *Regexp `toml:"enter"`
+		Leave *Regexp `toml:"leave"`
+		Rules []Rule  `toml:"rules"`
+
+		// Engine and Flags compile Enter and Leave, like the ones of a rule.
+		Engine string `toml:"engine"`
+		Flags  string `toml:"flags"`
 	}
 
 	Rule struct {
-		Regexp    *regexp.Regexp `toml:"regexp"`
-		Colors    string         `toml:"colors"`
-		Overwrite bool           `toml:"overwrite"`
-		Priority  int            `toml:"priority"`
+		Regexp    *Regexp `toml:"regexp"`
+		Colors    string  `toml:"colors"`
+		Overwrite bool    `toml:"overwrite"`
+		Priority  int     `toml:"priority"`
+
+		// Engine is the regexp engine, "re2" or "pcre", and Flags are
+		// regexp flags like "i" for case-insensitive matching.
+		Engine string `toml:"engine"`
+		Flags  string `toml:"flags"`
+
+		// Count is how the rule matches, like grc's count: "once" styles the
+		// first match only, "more" (the default) every match, and "stop" skips
+		// the rules after it. "block" styles the following lines until a rule
+		// with "unblock" matches.
+		Count string `toml:"count"`
+
+		// Start makes the rule a block: the lines from one matching Start to
+		// one matching End, or the indented lines after it with Indent, get
+		// the style of Colors. They are colorized with Rules instead of the
+		// other rules if the block has any.
+		Start  *Regexp `toml:"start"`
+		End    *Regexp `toml:"end"`
+		Indent bool    `toml:"indent"`
+		Rules  []Rule  `toml:"rules"`
+
+		// Column limits the rule to a column of a table. Without a regexp,
+		// the rule styles the whole cell.
+		Column string `toml:"column"`
+
+		// Thresholds and Gradient style the number in the first group of
+		// the regexp, like '85%' or '1.5G': with the style of the highest
+		// threshold it reaches, or with a color between the stops around it.
+		Thresholds []Threshold `toml:"thresholds"`
+		Gradient   []Stop      `toml:"gradient"`
+
+		// Map styles the same capture by its value, with the first mapping
+		// that applies. It's styled over the colors of the rule, so they're
+		// the default.
+		Map []Mapping `toml:"map"`
+
+		// Replace replaces the matches of the regexp with a template, where
+		// $1 or ${name} is a group of the match. Colors style the
+		// replacement. Phase is "before" (the default) to replace before the
+		// other rules style the line, so that they see the replacement, or
+		// "after" to replace styled text.
+		Replace *string `toml:"replace"`
+		Phase   string  `toml:"phase"`
+
+		// Action is what a match does to its line: "hide" drops it, "dim"
+		// styles it faint, and with "keep-only" rules only the lines that
+		// match one are printed.
+		Action string `toml:"action"`
+
+		// NamedStyles style the named groups of the regexp, like
+		// (?P<time>...), by name instead of by position in Colors. The pcre
+		// engine numbers named groups after the unnamed ones.
+		NamedStyles map[string]string `toml:"styles"`
+
+		// Palette are the colors of the hash styles of the rule, names or hex
+		// colors like '#ff8800'. DefaultPalette is used without one.
+		Palette []string `toml:"palette"`
+
+		// State and Table are set on the rules DecodeRules adds for the
+		// states and the table of a rules file, so that they're carried
+		// along with the other rules.
+		State *State `toml:"-"`
+		Table *Table `toml:"-"`
+
+		// Number is the 1-based position of the rule in its rules file. It is
+		// only used for reporting, since rules are reordered by SortRules.
+		Number int `toml:"-"`
+
+		styles []Style
 	}
 )
 
+// ruleCounts are the valid counts of a rule.
+var ruleCounts = []string{"", "once", "more", "stop", "block", "unblock"}
+
+// ruleActions are the valid actions of a rule.
+var ruleActions = []string{"", "hide", "keep-only", "dim"}
+
+// Filters reports whether the rule decides which lines are printed.
+func (r *Rule) Filters() bool {
+	return r.Action == "hide" || r.Action == "keep-only"
+}
+
+// Limit returns the number of matches the rule styles in a line, or -1 for
+// all of them.
+func (r *Rule) Limit() int {
+	if r.Count == "once" {
+		return 1
+	}
+	return -1
+}
+
+// Styles returns the parsed styles of the capture groups of the rule. They
+// are parsed once when the rules file is decoded.
+func (r *Rule) Styles() []Style {
+	if r.styles == nil {
+		return ParseStyles(r.Colors)
+	}
+	return r.styles
+}
+
+// HasStreams reports whether the rules file has separate rules for stdout and
+// stderr.
+func (c *CommandRules) HasStreams() bool {
+	return len(c.StdoutRules) > 0 || len(c.StderrRules) > 0
+}
+
+// Empty reports whether the rules file has no rules at all.
+func (c *CommandRules) Empty() bool {
+	return len(c.Rules) == 0 && !c.HasStreams()
+}
+
+// AllRules returns the rules of every stream, for output where stdout and
+// stderr are merged.
+func (c *CommandRules) AllRules() []Rule {
+	if !c.HasStreams() {
+		return c.Rules
+	}
+
+	rules := slices.Concat(c.Rules, c.StdoutRules, c.StderrRules)
+	SortRules(rules)
+	return rules
+}
+
+// StreamRules returns the rules of stdout, or of stderr, along with the rules
+// of both streams.
+func (c *CommandRules) StreamRules(stderr bool) []Rule {
+	rules := c.StdoutRules
+	if stderr {
+		rules = c.StderrRules
+	}
+
+	rules = slices.Concat(c.Rules, rules)
+	SortRules(rules)
+	return rules
+}
+
+// DecodeRules decodes the content of a rules file.
+func DecodeRules(content string) (*CommandRules, error) {
+	var file ruleFile
+	md, err := toml.Decode(content, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdRules := CommandRules{
+		Rules:       file.Rules,
+		PTY:         file.PTY,
+		StdoutRules: file.Stdout.Rules,
+	}
+
+	switch {
+	case md.Type("stderr") == "Bool":
+		err = md.PrimitiveDecode(file.Stderr, &cmdRules.Stderr)
+	case md.IsDefined("stderr"):
+		var stderr RuleSet
+		err = md.PrimitiveDecode(file.Stderr, &stderr)
+		cmdRules.StderrRules = stderr.Rules
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The rules of the states and the table go after the rules of the file,
+	// before they're sorted. States are entered in the order of the file.
+	for _, key := range md.Keys() {
+		if len(key) != 2 || key[0] != "states" {
+			continue
+		}
+
+		state := file.States[key[1]]
+		state.Name = key[1]
+		if err := state.prepare(); err != nil {
+			return nil, fmt.Errorf("state %s: %w", state.Name, err)
+		}
+		cmdRules.Rules = append(cmdRules.Rules, Rule{State: state})
+	}
+
+	if file.Table != nil {
+		if err := file.Table.prepare(); err != nil {
+			return nil, fmt.Errorf("table: %w", err)
+		}
+		cmdRules.Rules = append(cmdRules.Rules, Rule{Table: file.Table})
+	}
+
+	for _, rules := range [][]Rule{
+		cmdRules.Rules,
+		cmdRules.StdoutRules,
+		cmdRules.StderrRules,
+	} {
+		if err := prepareRules(rules, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cmdRules, nil
+}
+
+// add adds a rule to the rules of every stream, in the order of priority.
+func (c *CommandRules) add(rule Rule) {
+	c.Rules = append(c.Rules, rule)
+	SortRules(c.Rules)
+}
+
+// AddFilters adds rules that only keep the lines matching one of grep, and
+// hide the lines matching one of hide.
+func (c *CommandRules) AddFilters(grep []string, hide []string) error {
+	for i, expr := range slices.Concat(grep, hide) {
+		re, err := CompileRegexp(expr, "", "")
+		if err != nil {
+			return err
+		}
+
+		action := "hide"
+		if i < len(grep) {
+			action = "keep-only"
+		}
+		c.add(Rule{Regexp: re, Action: action})
+	}
+	return nil
+}
+
+// prepare checks and compiles a decoded state.
+func (s *State) prepare() error {
+	if s.Enter == nil {
+		return fmt.Errorf("a state needs enter")
+	}
+
+	for _, re := range []*Regexp{s.Enter, s.Leave} {
+		if re == nil {
+			continue
+		}
+		if err := re.Compile(s.Engine, s.Flags); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range s.Rules {
+		if rule.Filters() {
+			return fmt.Errorf("%s rules can't be in states", rule.Action)
+		}
+	}
+	return prepareRules(s.Rules, false)
+}
+
+// prepareRules numbers, checks and compiles decoded rules, and sorts them.
+// Nested rules are the rules of a block, which can't be blocks themselves.
+func prepareRules(rules []Rule, nested bool) error {
+	for i := range rules {
+		rule := &rules[i]
+		rule.Number = i + 1
+		rule.styles = ParseStyles(rule.Colors)
+
+		if err := rule.prepare(nested); err != nil {
+			return fmt.Errorf("rule %d: %w", rule.Number, err)
+		}
+	}
+	SortRules(rules)
+	return nil
+}
+
+// prepare checks and compiles a decoded rule.
+func (r *Rule) prepare(nested bool) error {
+	if !slices.Contains(ruleCounts, r.Count) {
+		return fmt.Errorf("unknown count %q", r.Count)
+	}
+
+	if !slices.Contains(ruleActions, r.Action) {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.Filters() && (nested || r.Column != "") {
+		return fmt.Errorf("%s rules can't be in blocks or columns", r.Action)
+	}
+
+	if r.Start != nil {
+		switch {
+		case nested:
+			return fmt.Errorf("blocks can't be nested")
+		case r.Regexp != nil:
+			return fmt.Errorf("a block has start instead of regexp")
+		case (r.End != nil) == r.Indent:
+			return fmt.Errorf("a block needs either end or indent")
+		}
+
+		if err := prepareRules(r.Rules, true); err != nil {
+			return err
+		}
+	} else if r.End != nil || r.Indent || r.Rules != nil {
+		return fmt.Errorf("end, indent and rules need a block start")
+	}
+
+	if r.Column != "" && r.Regexp == nil {
+		r.Regexp = &Regexp{expr: "^.*$"}
+	}
+
+	if err := r.prepareNumbers(); err != nil {
+		return err
+	}
+	if err := r.prepareMap(); err != nil {
+		return err
+	}
+
+	for _, re := range []*Regexp{r.Regexp, r.Start, r.End} {
+		if re == nil {
+			continue
+		}
+		if err := re.Compile(r.Engine, r.Flags); err != nil {
+			return err
+		}
+	}
+
+	if err := r.prepareNamedStyles(); err != nil {
+		return err
+	}
+	if err := r.prepareReplace(); err != nil {
+		return err
+	}
+
+	for _, color := range r.Palette {
+		if _, ok := ParseHexColor(color); !ok && GetColorCode(color) == "" {
+			return fmt.Errorf("invalid palette color %q", color)
+		}
+	}
+	for i := range r.styles {
+		r.styles[i].Palette = r.Palette
+	}
+	return nil
+}
+
+// prepareNamedStyles adds the styles of the named groups to the styles of the
+// rule. Groups without a style keep their style from Colors, if any.
+func (r *Rule) prepareNamedStyles() error {
+	if len(r.NamedStyles) == 0 {
+		return nil
+	}
+	if r.Regexp == nil {
+		return fmt.Errorf("styles need a regexp")
+	}
+
+	styles := make([]Style, len(r.Regexp.SubexpNames()))
+	if r.Colors != "" {
+		positional := r.Styles()
+		for i := range styles {
+			styles[i] = positional[i%len(positional)]
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(r.NamedStyles)) {
+		group := r.Regexp.SubexpIndex(name)
+		if group < 0 {
+			return fmt.Errorf("no group named %q for its style", name)
+		}
+
+		colors := r.NamedStyles[name]
+		if strings.Contains(colors, ",") {
+			return fmt.Errorf("the style of %q has more than one style", name)
+		}
+		styles[group] = ParseStyles(colors)[0]
+	}
+
+	r.styles = styles
+	return nil
+}
+
+// SortRules sorts rules that overwrite first, then by priority. Rules of the
+// same priority keep their order.
 func SortRules(rules []Rule) {
-	sort.Slice(rules, func(i int, j int) bool {
+	sort.SliceStable(rules, func(i int, j int) bool {
 		if rules[i].Overwrite != rules[j].Overwrite {
 			return rules[i].Overwrite
 		}
@@ -39,17 +441,26 @@ func SortRules(rules []Rule) {
 	})
 }
 
-func LoadRules(ruleFile string) (*CommandRules, error) {
-	var cmdRules CommandRules
+// LoadRulesFile loads the rules file at the given path without searching the
+// rules directories.
+func LoadRulesFile(path string) (*CommandRules, error) {
+	slog.Debug("Loading rules file", "path", path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
+	return DecodeRules(string(content))
+}
+
+func LoadRules(ruleFile string) (*CommandRules, error) {
 	if len(RulesDirectory) > 0 {
 		ruleFilePath := filepath.Join(RulesDirectory, ruleFile)
-		slog.Debug("Loading rules file", "path", ruleFilePath)
 
-		_, err := toml.DecodeFile(ruleFilePath, &cmdRules)
+		cmdRules, err := LoadRulesFile(ruleFilePath)
 		if err == nil {
-			SortRules(cmdRules.Rules)
-			return &cmdRules, err
+			return cmdRules, err
 		} else {
 			slog.Debug("Failed decoding toml file", "error", err)
 		}
@@ -96,14 +507,13 @@ func LoadRules(ruleFile string) (*CommandRules, error) {
 			continue
 		}
 
-		_, err = toml.Decode(string(content), &cmdRules)
+		cmdRules, err := DecodeRules(string(content))
 		if err != nil {
 			slog.Debug("Error decoding toml", "error", err)
 			continue
 		}
 
-		SortRules(cmdRules.Rules)
-		return &cmdRules, nil
+		return cmdRules, nil
 	}
 
 	ruleFilePath := filepath.Join("rules", ruleFile)
@@ -112,13 +522,7 @@ func LoadRules(ruleFile string) (*CommandRules, error) {
 
 	fileContentBytes, err := StaticRulesDirectory.ReadFile(ruleFilePath)
 	if err == nil {
-		_, err := toml.Decode(string(fileContentBytes), &cmdRules)
-		if err != nil {
-			return nil, err
-		}
-
-		SortRules(cmdRules.Rules)
-		return &cmdRules, err
+		return DecodeRules(string(fileContentBytes))
 	}
 
 	return nil, fmt.Errorf("No rules found")
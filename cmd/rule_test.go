@@ -0,0 +1,54 @@
+package cmd_test
+
+import (
+	"slices"
+	"testing"
+
+	"cshift/cmd"
+)
+
+// regexps returns the regexps of the rules.
+func regexps(rules []cmd.Rule) []string {
+	var exprs []string
+	for _, rule := range rules {
+		if rule.Regexp != nil {
+			exprs = append(exprs, rule.Regexp.String())
+		}
+	}
+	return exprs
+}
+
+func TestStreamRules(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[[rules]]
+regexp = 'both'
+colors = 'blue'
+
+[[stdout.rules]]
+regexp = 'out'
+colors = 'green'
+priority = -1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		rules    []cmd.Rule
+		expected []string
+	}{
+		{"stdout", cmdRules.StreamRules(false), []string{"out", "both"}},
+		{"stderr", cmdRules.StreamRules(true), []string{"both"}},
+		{"all", cmdRules.AllRules(), []string{"out", "both"}},
+	} {
+		if exprs := regexps(test.rules); !slices.Equal(exprs, test.expected) {
+			t.Errorf(
+				"expected the %s rules %q, but got %q",
+				test.name,
+				test.expected,
+				exprs,
+			)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import "bytes"
+
+// escapeParser collects the escape sequences in the output of a command.
+type escapeParser struct {
+	seq []byte
+}
+
+// maxEscapeLen limits how long an unterminated escape sequence is collected.
+const maxEscapeLen = 64
+
+// Feed adds a byte of output and returns the escape sequence it completes, if
+// any. The returned slice is only valid until the next call.
+func (p *escapeParser) Feed(char byte) []byte {
+	if char == '\x1b' && !p.inOSC() {
+		p.seq = append(p.seq[:0], '\x1b')
+		return nil
+	}
+
+	if len(p.seq) == 0 {
+		return nil
+	}
+
+	p.seq = append(p.seq, char)
+	if len(p.seq) > maxEscapeLen {
+		p.seq = p.seq[:0]
+		return nil
+	}
+
+	var done bool
+	switch {
+	case len(p.seq) == 2:
+		// CSI and OSC continue, charset selection takes one more byte
+		done = bytes.IndexByte([]byte("[]()*+#%"), char) < 0
+	case p.seq[1] == '[':
+		done = char >= 0x40 && char <= 0x7e
+	case p.seq[1] == ']':
+		done = char == '\a' || (char == '\\' && p.seq[len(p.seq)-2] == '\x1b')
+	default:
+		done = true
+	}
+
+	if !done {
+		return nil
+	}
+
+	seq := p.seq
+	p.seq = p.seq[:0]
+	return seq
+}
+
+// Active reports whether an escape sequence is being collected.
+func (p *escapeParser) Active() bool {
+	return len(p.seq) > 0
+}
+
+func (p *escapeParser) inOSC() bool {
+	return len(p.seq) > 1 && p.seq[1] == ']'
+}
+
+// screen tracks whether a command draws on the screen itself, either on the
+// alternate screen or by moving the cursor around. Its output is passed
+// through untouched while it does, since colorizing it as lines would corrupt
+// the screen.
+type screen struct {
+	// alternate is set while the alternate screen is used, e.g. by pagers
+	// and other full-screen programs.
+	alternate bool
+	// addressed is set when the cursor was moved on the current line, e.g.
+	// by a prompt redrawing itself. It is cleared at the end of the line.
+	addressed bool
+}
+
+// Raw reports whether the output should be passed through untouched.
+func (s *screen) Raw() bool {
+	return s.alternate || s.addressed
+}
+
+// Update updates the state with an escape sequence written by the command.
+func (s *screen) Update(seq []byte) {
+	if len(seq) == 2 {
+		// DECSC and DECRC save and restore the cursor position
+		if seq[1] == '7' || seq[1] == '8' {
+			s.addressed = true
+		}
+		return
+	}
+
+	if len(seq) < 3 || seq[1] != '[' {
+		return
+	}
+
+	params, final := seq[2:len(seq)-1], seq[len(seq)-1]
+
+	switch final {
+	case 'h', 'l':
+		if params, ok := bytes.CutPrefix(params, []byte("?")); ok {
+			for param := range bytes.SplitSeq(params, []byte(";")) {
+				switch string(param) {
+				case "1049", "1047", "47":
+					s.alternate = final == 'h'
+				}
+			}
+		}
+	case 'H', 'f', 'd', 'J':
+		// cursor position, line position and erase display
+		s.addressed = true
+	}
+}
+
+// cursorUp reports whether an escape sequence moves the cursor up, like
+// progress bars do to redraw the lines they printed. The lines that follow
+// are colorized like any other.
+func cursorUp(seq []byte) bool {
+	return len(seq) >= 3 && seq[1] == '[' && seq[len(seq)-1] == 'A'
+}
+
+// EndLine is called at the end of every line of output.
+func (s *screen) EndLine() {
+	s.addressed = false
+}
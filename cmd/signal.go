@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Forwarder relays the signals received by cshift to the command for as long
+// as it runs, and suspends cshift together with the command.
+//
+// When cshift is the foreground job of a terminal and the command shares its
+// process group, the terminal already delivers SIGINT, SIGQUIT and SIGTSTP to
+// both. Those are not relayed again, so the command doesn't receive them twice.
+// Otherwise the command runs in its own process group (or session, in PTY
+// mode) and every signal is relayed to that group.
+type Forwarder struct {
+	// Suspend and Resume are called before cshift stops itself on SIGTSTP and
+	// after it is continued, e.g. to restore and reapply terminal settings.
+	Suspend func()
+	Resume  func()
+
+	cmd      *exec.Cmd
+	ownGroup bool
+	pty      bool
+	signals  chan os.Signal
+}
+
+// NewForwarder starts listening for signals to relay to cmd, which must not be
+// started yet. Signals received before Start are relayed once it's called.
+func NewForwarder(cmd *exec.Cmd, pty bool) *Forwarder {
+	f := &Forwarder{
+		cmd:      cmd,
+		ownGroup: pty || !isForeground(),
+		pty:      pty,
+		signals:  make(chan os.Signal, 8),
+	}
+
+	// pty.Start already runs the command in its own session.
+	if f.ownGroup && !pty {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
+	}
+
+	signals := []os.Signal{
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGHUP,
+		syscall.SIGQUIT,
+		syscall.SIGUSR1,
+		syscall.SIGUSR2,
+	}
+	if f.ownGroup {
+		signals = append(signals, syscall.SIGTSTP, syscall.SIGCONT)
+	}
+
+	signal.Notify(f.signals, signals...)
+	return f
+}
+
+// Start relays the signals to the started command.
+func (f *Forwarder) Start() {
+	if f == nil {
+		return
+	}
+
+	go func() {
+		defer restoreOnPanic()
+		for sig := range f.signals {
+			f.handle(sig.(syscall.Signal))
+		}
+	}()
+}
+
+// Stop stops relaying signals.
+func (f *Forwarder) Stop() {
+	if f == nil {
+		return
+	}
+	signal.Stop(f.signals)
+}
+
+func (f *Forwarder) handle(sig syscall.Signal) {
+	slog.Debug("Received signal", "signal", sig)
+
+	if f.ownGroup {
+		switch sig {
+		case syscall.SIGTSTP:
+			// The command is continued by relaying the SIGCONT that
+			// continues cshift, once it's resumed.
+			f.signal(f.stopSignal())
+			f.suspend()
+		default:
+			f.signal(sig)
+		}
+		return
+	}
+
+	switch sig {
+	case syscall.SIGINT, syscall.SIGQUIT:
+		// already delivered by the terminal
+	default:
+		f.signal(sig)
+	}
+}
+
+// suspendInput suspends the command and cshift when input typed on the
+// terminal has the suspend character, like Ctrl-Z, and returns the input
+// without it. The pty of the command only sends SIGTSTP to its foreground process
+// group, which the kernel discards when it's the orphaned group of the
+// command itself. The input is returned unchanged when the command runs a job
+// in the foreground instead, like a shell does, or handles the characters
+// itself.
+func (f *Forwarder) suspendInput(ptmx *os.File, input []byte) []byte {
+	if f == nil {
+		return input
+	}
+
+	fd := int(ptmx.Fd())
+	t, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil || t.Lflag&unix.ISIG == 0 || t.Cc[unix.VSUSP] == 0 {
+		return input
+	}
+	pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || pgrp != f.cmd.Process.Pid {
+		return input
+	}
+
+	susp := t.Cc[unix.VSUSP]
+	if bytes.IndexByte(input, susp) < 0 {
+		return input
+	}
+
+	// The signals are handled in order, so the SIGCONT is relayed after
+	// cshift is resumed.
+	select {
+	case f.signals <- syscall.SIGTSTP:
+	default:
+	}
+	return bytes.ReplaceAll(input, []byte{susp}, nil)
+}
+
+// stopSignal returns the signal that stops the command. In PTY mode the
+// command leads its own session, so its process group is orphaned and the
+// kernel discards SIGTSTP sent to it.
+func (f *Forwarder) stopSignal() syscall.Signal {
+	if f.pty {
+		return syscall.SIGSTOP
+	}
+	return syscall.SIGTSTP
+}
+
+// suspend stops cshift until it is continued.
+func (f *Forwarder) suspend() {
+	if f.Suspend != nil {
+		f.Suspend()
+	}
+
+	// SIGSTOP is delivered asynchronously, so cshift waits until it's
+	// continued before the command is.
+	cont := make(chan os.Signal, 1)
+	signal.Notify(cont, syscall.SIGCONT)
+	defer signal.Stop(cont)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGSTOP); err != nil {
+		slog.Debug("Error suspending", "error", err)
+	} else {
+		<-cont
+	}
+
+	if f.Resume != nil {
+		f.Resume()
+	}
+}
+
+// signal sends the signal to the command, or to its process group when it
+// has its own.
+func (f *Forwarder) signal(sig syscall.Signal) {
+	pid := f.cmd.Process.Pid
+	if f.ownGroup {
+		pid = -pid
+	}
+
+	if err := syscall.Kill(pid, sig); err != nil {
+		slog.Debug("Error sending signal to process", "error", err)
+	}
+}
+
+// isForeground reports whether cshift is in the foreground process group of
+// its controlling terminal.
+func isForeground() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		pgrp, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+		if err == nil {
+			return pgrp == unix.Getpgrp()
+		}
+	}
+	return false
+}
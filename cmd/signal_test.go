@@ -0,0 +1,117 @@
+package cmd_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+
+	"cshift/cmd"
+)
+
+// processState returns the state of a process in /proc, like "S" or "T" for
+// stopped.
+func processState(t *testing.T, pid int) string {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The state follows the command name, which is in parentheses.
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return fields[0]
+}
+
+// waitState waits until a process that isn't a child is in state.
+func waitState(t *testing.T, pid int, state string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current := processState(t, pid)
+		if current == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d in state %s, but got %s", pid, state, current)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitChild waits until the child is stopped, or continued.
+func waitChild(t *testing.T, pid int, continued bool) {
+	options := syscall.WUNTRACED
+	if continued {
+		options = syscall.WCONTINUED
+	}
+
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &status, options, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status.Stopped() == continued || status.Continued() != continued {
+		t.Fatalf(
+			"expected %d to be continued: %v, but got %v",
+			pid,
+			continued,
+			status,
+		)
+	}
+}
+
+func TestForwarderSuspendPTY(t *testing.T) {
+	if os.Getenv("CSHIFT_TEST_FORWARDER") != "" {
+		sleep := exec.Command("sleep", "30")
+		forwarder := cmd.NewForwarder(sleep, true)
+		ptmx, err := pty.Start(sleep)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer ptmx.Close()
+		forwarder.Start()
+
+		fmt.Println(sleep.Process.Pid)
+		_ = sleep.Wait()
+		os.Exit(0)
+	}
+
+	test := exec.Command(os.Args[0], "-test.run=^TestForwarderSuspendPTY$")
+	test.Env = append(os.Environ(), "CSHIFT_TEST_FORWARDER=1")
+	stdout, err := test.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = test.Process.Kill(); _ = test.Wait() }()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("expected the pid of the command, but got %q", line)
+	}
+	defer func() { _ = syscall.Kill(child, syscall.SIGKILL) }()
+
+	if err := test.Process.Signal(syscall.SIGTSTP); err != nil {
+		t.Fatal(err)
+	}
+	waitChild(t, test.Process.Pid, false)
+	waitState(t, child, "T")
+
+	if err := test.Process.Signal(syscall.SIGCONT); err != nil {
+		t.Fatal(err)
+	}
+	waitChild(t, test.Process.Pid, true)
+	waitState(t, child, "S")
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import "strings"
+
+type (
+	// Table makes the output a table: rules with a column only apply to
+	// that column of the lines after a header line. The header is the line
+	// matching Header, or the first line without one. Column names are the
+	// words of the header, or Columns if given, so that they can have spaces.
+	Table struct {
+		Header  *Regexp  `toml:"header"`
+		Columns []string `toml:"columns"`
+
+		// Engine and Flags compile Header, like the ones of a rule.
+		Engine string `toml:"engine"`
+		Flags  string `toml:"flags"`
+	}
+
+	// Columns are the columns of a table, found in its header line.
+	Columns struct {
+		Names  []string
+		Starts []int
+		Ends   []int
+	}
+
+	// Cell is the part of a line in a column.
+	Cell struct {
+		Start int
+		End   int
+	}
+)
+
+// prepare checks and compiles a decoded table.
+func (t *Table) prepare() error {
+	if t.Header == nil {
+		return nil
+	}
+	return t.Header.Compile(t.Engine, t.Flags)
+}
+
+// Parse finds the columns in a header line.
+func (t *Table) Parse(header string) *Columns {
+	var columns Columns
+
+	if len(t.Columns) == 0 {
+		for _, field := range fields(header) {
+			columns.Names = append(columns.Names, header[field.Start:field.End])
+			columns.Starts = append(columns.Starts, field.Start)
+			columns.Ends = append(columns.Ends, field.End)
+		}
+		return &columns
+	}
+
+	pos := 0
+	for _, name := range t.Columns {
+		i := strings.Index(header[pos:], name)
+		if i < 0 {
+			continue
+		}
+
+		columns.Names = append(columns.Names, name)
+		columns.Starts = append(columns.Starts, pos+i)
+		columns.Ends = append(columns.Ends, pos+i+len(name))
+		pos += i + len(name)
+	}
+	return &columns
+}
+
+// Cells splits a line of the table into the cells of its columns. Every word
+// goes to the column whose name it overlaps the most, or to the closest one.
+// Words after the start of the last column belong to it, since the last
+// column is often free text, and words before the first column to none.
+func (c *Columns) Cells(line string) map[string]Cell {
+	cells := map[string]Cell{}
+	if len(c.Names) == 0 {
+		return cells
+	}
+
+	last := len(c.Names) - 1
+	for _, field := range fields(line) {
+		var column int
+		switch {
+		case field.End > c.Starts[last]:
+			column = last
+		case field.End <= c.Starts[0]:
+			continue
+		default:
+			column = c.closest(field)
+		}
+
+		name := c.Names[column]
+		cell, ok := cells[name]
+		if !ok {
+			cells[name] = field
+			continue
+		}
+		cells[name] = Cell{
+			min(cell.Start, field.Start),
+			max(cell.End, field.End),
+		}
+	}
+
+	return cells
+}
+
+// closest returns the column the field overlaps the most, or the closest one
+// if it overlaps none.
+func (c *Columns) closest(field Cell) int {
+	best, bestOverlap, bestDistance := 0, 0, -1
+	for i := range c.Names {
+		start, end := c.Starts[i], c.Ends[i]
+		overlap := min(end, field.End) - max(start, field.Start)
+		distance := max(start-field.End, field.Start-end, 0)
+
+		switch {
+		case overlap > bestOverlap:
+			best, bestOverlap = i, overlap
+		case bestOverlap == 0 && (bestDistance < 0 || distance < bestDistance):
+			best, bestDistance = i, distance
+		}
+	}
+	return best
+}
+
+// fields returns the parts of s separated by spaces.
+func fields(s string) []Cell {
+	var cells []Cell
+	start := -1
+	for i := range len(s) {
+		space := s[i] == ' ' || s[i] == '\t'
+		switch {
+		case !space && start < 0:
+			start = i
+		case space && start >= 0:
+			cells = append(cells, Cell{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		cells = append(cells, Cell{start, len(s)})
+	}
+	return cells
+}
+
+// enterTable updates the state with the table of the rules for the line, and
+// reports whether the line is a header.
+func enterTable(line string, rules []Rule, state *LineState) bool {
+	for _, rule := range rules {
+		table := rule.Table
+		if table == nil {
+			continue
+		}
+
+		header := state.Columns == nil && strings.TrimSpace(line) != ""
+		if table.Header != nil {
+			header = table.Header.MatchString(line)
+		}
+		if header {
+			state.Columns = table.Parse(line)
+		}
+		return header
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"cshift/cmd"
+)
+
+func TestColumnsCells(t *testing.T) {
+	table := cmd.Table{Columns: []string{"CONTAINER ID", "CREATED", "NAMES"}}
+	columns := table.Parse("CONTAINER ID   CREATED        NAMES")
+
+	line := "3f2a1b0c9d8e   2 hours ago    web server"
+	cells := columns.Cells(line)
+
+	expected := map[string]string{
+		"CONTAINER ID": "3f2a1b0c9d8e",
+		"CREATED":      "2 hours ago",
+		"NAMES":        "web server",
+	}
+	for name, value := range expected {
+		cell := cells[name]
+		if got := line[cell.Start:cell.End]; got != value {
+			t.Errorf("expected %q in %s, but got %q", value, name, got)
+		}
+	}
+}
+
+func TestColorizeTable(t *testing.T) {
+	cmdRules, err := cmd.DecodeRules(`
+[table]
+header = '^Filesystem'
+
+[[rules]]
+column = 'Use%'
+regexp = '^9\d%$'
+colors = 'red'
+
+[[rules]]
+column = 'Avail'
+colors = 'green'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "99% before\n" +
+		"Filesystem  Size Avail Use% Mounted on\n" +
+		"/dev/sda1    99G   10G  91% /mnt/99%\n"
+	expected := "> 99% before\x1b[0m\n" +
+		"> Filesystem  Size Avail Use% Mounted on\x1b[0m\n" +
+		"> /dev/sda1    99G   \x1b[32m10G\x1b[0m  " +
+		"\x1b[31m91%\x1b[0m /mnt/99%\x1b[0m\n"
+	colored := string(colorizeFile(t, cmdRules.Rules, []byte(input)))
+	if colored != expected {
+		t.Fatalf("expected %q, but got %q", expected, colored)
+	}
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Terminal is a terminal whose settings are changed while a command runs in
+// PTY mode.
+type Terminal struct {
+	fd    int
+	state *unix.Termios
+}
+
+// rawTerminal is the terminal in raw mode, restored on every way out of
+// cshift, since a terminal left in raw mode is unusable.
+var rawTerminal atomic.Pointer[Terminal]
+
+// MakeRaw puts the terminal of f in raw mode and returns it so that it can be
+// restored. Echo and line editing are left to the pty of the command, which
+// prevents double echo and line buffering.
+//
+// Like cfmakeraw(3), the terminal doesn't generate signals: Ctrl-C, Ctrl-\ and
+// Ctrl-Z are written to the pty, whose line discipline signals the command, or
+// the command handles them itself.
+func MakeRaw(f *os.File) (*Terminal, error) {
+	fd := int(f.Fd())
+	state, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Terminal{fd: fd, state: state}
+	if err := t.MakeRaw(); err != nil {
+		return nil, err
+	}
+	rawTerminal.Store(t)
+	return t, nil
+}
+
+// MakeRaw puts the terminal back in raw mode after it was restored.
+func (t *Terminal) MakeRaw() error {
+	raw := *t.state
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG |
+		unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(t.fd, ioctlSetTermios, &raw)
+}
+
+// Restore restores the settings the terminal had before MakeRaw.
+func (t *Terminal) Restore() error {
+	return unix.IoctlSetTermios(t.fd, ioctlSetTermios, t.state)
+}
+
+// restoreTerminal restores the terminal in raw mode, if any.
+func restoreTerminal() {
+	if t := rawTerminal.Load(); t != nil {
+		_ = t.Restore()
+	}
+}
+
+// restoreOnPanic restores the terminal before a panic crashes cshift. It's
+// deferred by the goroutines that run while the terminal is in raw mode.
+func restoreOnPanic() {
+	if err := recover(); err != nil {
+		restoreTerminal()
+		panic(err)
+	}
+}
+
+// fatalSignals are the signals that terminate cshift without being relayed
+// to the command.
+var fatalSignals = []os.Signal{
+	syscall.SIGABRT,
+	syscall.SIGALRM,
+	syscall.SIGPIPE,
+	syscall.SIGSYS,
+	syscall.SIGTRAP,
+	syscall.SIGVTALRM,
+	syscall.SIGXCPU,
+	syscall.SIGXFSZ,
+}
+
+// restoreOnSignal restores the terminal before a signal terminates cshift, and
+// returns a function that stops it.
+func restoreOnSignal() func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, fatalSignals...)
+
+	go func() {
+		sig, ok := <-ch
+		if !ok {
+			return
+		}
+
+		restoreTerminal()
+		exitSignal(sig.(syscall.Signal))
+	}()
+
+	return func() { signal.Stop(ch); close(ch) }
+}
+
+// isTTY reports whether f is a terminal.
+func isTTY(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlGetTermios)
+	return err == nil
+}
+
+// terminalFile returns the first of stdin, stdout and stderr that is a
+// terminal, or nil if none is.
+func terminalFile() *os.File {
+	for _, f := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		if isTTY(f) {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+
+	"cshift/cmd"
+)
+
+func TestMakeRaw(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+
+	lflag := func() uint64 {
+		termios, err := unix.IoctlGetTermios(int(tty.Fd()), unix.TCGETS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uint64(termios.Lflag)
+	}
+
+	const cooked = unix.ECHO | unix.ICANON | unix.ISIG
+	if lflag()&cooked != cooked {
+		t.Fatalf("expected a new pty in cooked mode, but got %#x", lflag())
+	}
+
+	term, err := cmd.MakeRaw(tty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags := lflag() & cooked; flags != 0 {
+		t.Errorf(
+			"expected no echo, line editing or signals, but got %#x",
+			flags,
+		)
+	}
+
+	if err := term.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if lflag()&cooked != cooked {
+		t.Errorf("expected the pty restored, but got %#x", lflag())
+	}
+}
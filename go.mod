@@ -8,11 +8,13 @@ require (
 	github.com/adrg/xdg v0.5.3
 	github.com/carapace-sh/carapace v1.9.1
 	github.com/creack/pty v1.1.24
+	github.com/dlclark/regexp2 v1.12.0
 	github.com/fatih/color v1.18.0
 	github.com/gobwas/glob v0.2.3
 	github.com/ivanpirog/coloredcobra v1.0.1
 	github.com/muesli/termenv v0.16.0
 	github.com/spf13/cobra v1.9.1
+	golang.org/x/sys v0.35.0
 )
 
 require (
@@ -24,6 +26,5 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
-	golang.org/x/sys v0.35.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )